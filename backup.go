@@ -0,0 +1,295 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// backupEntries are always backed up before a migration or upgrade; the config's
+// BackupExtras adds more folders of user data.
+var backupEntries = []string{"saves"}
+
+// backupManifestName is the file inside every backup archive describing it.
+const backupManifestName = "gtnh-backup.json"
+
+// Backup formats. The store only keeps what changed since the last backup; a zip
+// is a complete copy that opens without the updater.
+const (
+	backupFormatStore = "store"
+	backupFormatZip   = "zip"
+)
+
+// defaultBackupDir keeps backups next to the launcher's instances folder rather
+// than inside it, where the launcher would take them for an instance.
+func defaultBackupDir(instancePath string) string {
+	return filepath.Join(filepath.Dir(filepath.Dir(instancePath)), "gtnh-backups")
+}
+
+// configuredBackupDir is where the backups of instance go.
+func configuredBackupDir(cfg *config, instance string) string {
+	if cfg != nil && cfg.BackupDir != "" {
+		return cfg.BackupDir
+	}
+	return defaultBackupDir(instance)
+}
+
+// backupPlan is the backup step of a migration plan.
+type backupPlan struct {
+	Enabled  bool     `json:"enabled"`
+	Format   string   `json:"format"`
+	Instance string   `json:"instance"`
+	Root     string   `json:"root"`
+	Dir      string   `json:"dir"`
+	Entries  []string `json:"entries"`
+	Files    int      `json:"files"`
+	Bytes    int64    `json:"bytes"`
+
+	Retention *retentionPolicy `json:"retention,omitempty"`
+}
+
+type backupManifest struct {
+	Instance string    `json:"instance"`
+	Created  time.Time `json:"created"`
+	Entries  []string  `json:"entries"`
+	Worlds   []string  `json:"worlds,omitempty"`
+	Files    int       `json:"files"`
+	Bytes    int64     `json:"bytes"`
+}
+
+type backupResult struct {
+	Format string `json:"format"`
+	// Archive is the zip, or the store folder for a snapshot.
+	Archive  string `json:"archive"`
+	Snapshot string `json:"snapshot,omitempty"`
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+	// Size is the size of the archive itself, or what the snapshot added to the store.
+	Size int64 `json:"size"`
+	// Pruned are older archives of the same instance the retention policy removed.
+	Pruned     []string `json:"pruned,omitempty"`
+	PruneError string   `json:"pruneError,omitempty"`
+}
+
+func planBackup(source string, cfg *config) (*backupPlan, error) {
+	bp := &backupPlan{Enabled: true, Format: backupFormatStore, Instance: source, Root: destinationRoot(source, pathExists), Dir: configuredBackupDir(cfg, source)}
+	entries := backupEntries
+	if cfg != nil {
+		bp.Enabled = !cfg.SkipBackup
+		if cfg.BackupFormat != "" {
+			if cfg.BackupFormat != backupFormatStore && cfg.BackupFormat != backupFormatZip {
+				return nil, fmt.Errorf("backupFormat must be %q or %q, not %q", backupFormatStore, backupFormatZip, cfg.BackupFormat)
+			}
+			bp.Format = cfg.BackupFormat
+		}
+		bp.Retention = cfg.Retention
+		entries = append(append([]string{}, entries...), cfg.BackupExtras...)
+	}
+	for _, e := range entries {
+		if !pathExists(filepath.Join(bp.Root, e)) {
+			continue
+		}
+		err := walkBackupEntry(bp.Root, e, func(_ string, info fs.FileInfo) error {
+			if !info.IsDir() {
+				bp.Files++
+				bp.Bytes += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan %s: %w", e, err)
+		}
+		bp.Entries = append(bp.Entries, e)
+	}
+	if len(bp.Entries) == 0 {
+		return nil, nil
+	}
+	return bp, nil
+}
+
+// estimatedBytes is how much the backup adds to the backup folder: the full size
+// for a zip, and what the store does not hold yet for a snapshot.
+func (bp *backupPlan) estimatedBytes() (int64, error) {
+	if bp.Format == backupFormatZip {
+		return bp.Bytes, nil
+	}
+	return (&backupStore{dir: backupStoreDir(bp.Dir)}).newBytes(bp)
+}
+
+// walkBackupEntry calls fn for the folders and regular files of entry under root,
+// with their paths relative to root. Symbolic links are followed, so a linked
+// saves folder is backed up with what it holds rather than as a link; links back
+// to a folder above them and dangling links are left out.
+func walkBackupEntry(root, entry string, fn func(rel string, info fs.FileInfo) error) error {
+	return walkTree(filepath.Join(root, entry), true, func(p string, info fs.FileInfo) error {
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		return fn(rel, info)
+	}, nil)
+}
+
+// backupWorlds backs up the planned entries in the plan's format and then applies
+// the retention policy.
+func backupWorlds(ctx context.Context, bp *backupPlan, progress func(done, total int64)) (*backupResult, error) {
+	if bp == nil || !bp.Enabled {
+		return nil, nil
+	}
+	var (
+		res *backupResult
+		err error
+	)
+	if bp.Format == backupFormatZip {
+		res, err = backupZip(ctx, bp, progress)
+	} else {
+		res, err = backupSnapshot(ctx, bp, progress)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// a failed prune leaves old backups around, which is no reason to stop
+	if err := res.prune(bp); err != nil {
+		res.PruneError = err.Error()
+	}
+	return res, nil
+}
+
+// backupSnapshot adds a snapshot of the planned entries to the backup store.
+func backupSnapshot(ctx context.Context, bp *backupPlan, progress func(done, total int64)) (*backupResult, error) {
+	store, err := openBackupStore(backupStoreDir(bp.Dir))
+	if err != nil {
+		return nil, err
+	}
+	snap, err := store.take(ctx, bp, progress)
+	if err != nil {
+		return nil, err
+	}
+	return &backupResult{Format: backupFormatStore, Archive: store.dir, Snapshot: snap.ID, Files: len(snap.Files), Bytes: snap.Bytes, Size: snap.Added}, nil
+}
+
+// backupZip writes the planned entries into a timestamped zip in the backup
+// folder. The archive only gets its final name once it is complete, so a
+// cancelled backup never looks like a good one.
+func backupZip(ctx context.Context, bp *backupPlan, progress func(done, total int64)) (*backupResult, error) {
+	if err := os.MkdirAll(bp.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	archive := filepath.Join(bp.Dir, fmt.Sprintf("%s-%s.zip", filepath.Base(bp.Instance), now.Format("20060102-150405")))
+	tmp := archive + ".partial"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	manifest := backupManifest{Instance: bp.Instance, Created: now, Entries: bp.Entries}
+	var done int64
+	for _, entry := range bp.Entries {
+		err := walkBackupEntry(bp.Root, entry, func(rel string, info fs.FileInfo) error {
+			if info.IsDir() {
+				if entry == "saves" && filepath.Dir(rel) == "saves" {
+					manifest.Worlds = append(manifest.Worlds, filepath.Base(rel))
+				}
+				return nil
+			}
+			hdr, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			hdr.Name = filepath.ToSlash(rel)
+			hdr.Method = zip.Deflate
+			w, err := zw.CreateHeader(hdr)
+			if err != nil {
+				return err
+			}
+			src, err := os.Open(filepath.Join(bp.Root, rel))
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			if err := copyContext(ctx, w, src, func(n int64) {
+				done += n
+				if progress != nil {
+					progress(done, bp.Bytes)
+				}
+			}); err != nil {
+				return err
+			}
+			manifest.Files++
+			manifest.Bytes += info.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry, err)
+		}
+	}
+	sort.Strings(manifest.Worlds)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: backupManifestName, Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := mw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, archive); err != nil {
+		return nil, err
+	}
+	res := &backupResult{Format: backupFormatZip, Archive: archive, Files: manifest.Files, Bytes: manifest.Bytes}
+	if info, err := os.Stat(archive); err == nil {
+		res.Size = info.Size()
+	}
+	return res, nil
+}
+
+// prune applies the retention policy to the backups of bp's instance.
+func (res *backupResult) prune(bp *backupPlan) error {
+	if !bp.Retention.active() {
+		return nil
+	}
+	if res.Format == backupFormatStore {
+		store, err := openBackupStore(backupStoreDir(bp.Dir))
+		if err != nil {
+			return err
+		}
+		dropped, err := store.prune(bp.Instance, bp.Retention, false)
+		for _, it := range dropped {
+			res.Pruned = append(res.Pruned, it.Path)
+		}
+		return err
+	}
+	groups, err := listBackupArchives(bp.Dir)
+	if err != nil {
+		return err
+	}
+	dropped, err := pruneBackups(map[string][]backupItem{bp.Instance: groups[bp.Instance]}, bp.Retention, false)
+	for _, it := range dropped {
+		res.Pruned = append(res.Pruned, it.Path)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// linkedSaves makes an instance whose saves folder is a symbolic link to a folder
+// elsewhere, holding one world with a linked file inside it.
+func linkedSaves(t *testing.T) (instance string, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	instance = filepath.Join(dir, "instance")
+	worlds := filepath.Join(dir, "worlds")
+	writeFile(t, filepath.Join(worlds, "w", "level.dat"), []byte("level"))
+	writeFile(t, filepath.Join(worlds, "w", "region", "r.0.0.mca"), []byte("region"))
+	writeFile(t, filepath.Join(dir, "notes.txt"), []byte("notes"))
+	if err := os.Symlink(filepath.Join(dir, "notes.txt"), filepath.Join(worlds, "w", "notes.txt")); err != nil {
+		t.Skip("symbolic links not supported:", err)
+	}
+	if err := os.MkdirAll(filepath.Join(instance, ".minecraft"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(worlds, filepath.Join(instance, ".minecraft", "saves")); err != nil {
+		t.Fatal(err)
+	}
+	return instance, map[string]string{
+		"saves/w/level.dat":        "level",
+		"saves/w/region/r.0.0.mca": "region",
+		"saves/w/notes.txt":        "notes",
+	}
+}
+
+func TestBackupZipFollowsLinks(t *testing.T) {
+	instance, want := linkedSaves(t)
+	bp, err := planBackup(instance, &config{BackupDir: t.TempDir(), BackupFormat: backupFormatZip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bp == nil || bp.Files != len(want) {
+		t.Fatalf("planned %+v, want %d files", bp, len(want))
+	}
+	res, err := backupZip(context.Background(), bp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(res.Archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	got := make(map[string]string)
+	for _, f := range zr.File {
+		if f.Name == backupManifestName {
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			t.Errorf("%s stored with mode %v", f.Name, f.Mode())
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[f.Name] = string(data)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+	m, err := readBackupManifest(res.Archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(m.Worlds)
+	if !reflect.DeepEqual(m.Worlds, []string{"w"}) {
+		t.Errorf("manifest worlds %v, want [w]", m.Worlds)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The pristine config of every pack we install is kept inside the instance so the
+// next upgrade has the old defaults to merge against.
+func configBaselineDir(instancePath string) string {
+	return filepath.Join(updaterDir(instancePath), "baseline", "config")
+}
+
+// configMergePlan is the config merge step of a migration plan.
+type configMergePlan struct {
+	SourceDir   string `json:"sourceDir"`
+	BaselineDir string `json:"baselineDir"`
+	HasBaseline bool   `json:"hasBaseline"`
+}
+
+func planConfigMerge(source string) *configMergePlan {
+	src, ok := firstExistingPath("config", instanceRoots(source))
+	if !ok {
+		return nil
+	}
+	baseline := configBaselineDir(source)
+	return &configMergePlan{SourceDir: src, BaselineDir: baseline, HasBaseline: pathExists(baseline)}
+}
+
+type cfgConflict struct {
+	Key  string `json:"key"`
+	Base string `json:"base"`
+	User string `json:"user"`
+	New  string `json:"new"`
+}
+
+type cfgFileMerge struct {
+	Path      string        `json:"path"`
+	Kept      []string      `json:"kept,omitempty"`
+	Conflicts []cfgConflict `json:"conflicts,omitempty"`
+	Dropped   []string      `json:"dropped,omitempty"`
+}
+
+type configMergeResult struct {
+	Files []cfgFileMerge `json:"files,omitempty"`
+	// NoBaseline are the files merged without the old pack's defaults, keeping
+	// every value of the user's the new pack still has.
+	NoBaseline []string `json:"noBaseline,omitempty"`
+}
+
+func (r *configMergeResult) counts() (kept, conflicts int) {
+	for _, f := range r.Files {
+		kept += len(f.Kept)
+		conflicts += len(f.Conflicts)
+	}
+	return kept, conflicts
+}
+
+// snapshotConfigBaseline stores the freshly extracted pack's .cfg files so a later
+// upgrade of this instance can tell the user's edits apart from pack defaults.
+func snapshotConfigBaseline(instancePath, gameRoot string) error {
+	configDir := filepath.Join(gameRoot, "config")
+	if !pathExists(configDir) {
+		return nil
+	}
+	baseline := configBaselineDir(instancePath)
+	if err := os.RemoveAll(baseline); err != nil {
+		return err
+	}
+	return filepath.WalkDir(configDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.EqualFold(filepath.Ext(p), ".cfg") {
+			return err
+		}
+		rel, err := filepath.Rel(configDir, p)
+		if err != nil {
+			return err
+		}
+		return copyFile(p, filepath.Join(baseline, rel))
+	})
+}
+
+// mergeInstanceConfigs three-way merges every .cfg file of the new pack with the
+// user's copy from the source instance, using the source's baseline as the common
+// ancestor. User changes survive when the pack default did not move; keys changed
+// on both sides keep the new default and are reported as conflicts. Instances
+// installed before baselines were recorded have none; their files are merged two
+// ways, keeping the user's value of every key the new pack still has, since an
+// edit cannot be told apart from an old default there.
+func mergeInstanceConfigs(mp *configMergePlan, gameRoot string) (*configMergeResult, error) {
+	result := &configMergeResult{}
+	if mp == nil {
+		return result, nil
+	}
+	newDir := filepath.Join(gameRoot, "config")
+	if !pathExists(newDir) {
+		return result, nil
+	}
+	err := filepath.WalkDir(newDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.EqualFold(filepath.Ext(p), ".cfg") {
+			return err
+		}
+		rel, err := filepath.Rel(newDir, p)
+		if err != nil {
+			return err
+		}
+		userPath := filepath.Join(mp.SourceDir, rel)
+		if !pathExists(userPath) {
+			return nil
+		}
+		basePath := filepath.Join(mp.BaselineDir, rel)
+		if !pathExists(basePath) {
+			if sameFileContents(userPath, p) {
+				return nil
+			}
+			result.NoBaseline = append(result.NoBaseline, filepath.ToSlash(rel))
+			basePath = ""
+		}
+		fm, err := mergeConfigFile(basePath, userPath, p)
+		if err != nil {
+			return fmt.Errorf("merge %s: %w", rel, err)
+		}
+		if len(fm.Kept)+len(fm.Conflicts)+len(fm.Dropped) > 0 {
+			fm.Path = filepath.ToSlash(rel)
+			result.Files = append(result.Files, fm)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// mergeConfigFile merges userPath into newPath in place. Without a basePath every
+// value of the user's that differs from the new pack's is kept.
+func mergeConfigFile(basePath, userPath, newPath string) (cfgFileMerge, error) {
+	var fm cfgFileMerge
+	base := parseForgeConfig(nil)
+	if basePath != "" {
+		var err error
+		if base, err = readForgeConfig(basePath); err != nil {
+			return fm, err
+		}
+	}
+	user, err := readForgeConfig(userPath)
+	if err != nil {
+		return fm, err
+	}
+	next, err := readForgeConfig(newPath)
+	if err != nil {
+		return fm, err
+	}
+
+	values := make(map[string]string)
+	for _, p := range next.props {
+		u, inUser := user.get(p.key)
+		if !inUser || u == p.value {
+			continue
+		}
+		b, inBase := base.get(p.key)
+		switch {
+		case basePath == "":
+			values[p.key] = u
+			fm.Kept = append(fm.Kept, p.key)
+		case inBase && u == b:
+			// the user never touched it; take the new default
+		case inBase && p.value == b:
+			values[p.key] = u
+			fm.Kept = append(fm.Kept, p.key)
+		default:
+			fm.Conflicts = append(fm.Conflicts, cfgConflict{Key: p.key, Base: b, User: u, New: p.value})
+		}
+	}
+	for _, p := range user.props {
+		if _, inNew := next.get(p.key); inNew {
+			continue
+		}
+		if b, inBase := base.get(p.key); inBase && b == p.value {
+			continue
+		}
+		fm.Dropped = append(fm.Dropped, p.key)
+	}
+	sort.Strings(fm.Dropped)
+
+	if len(values) == 0 {
+		return fm, nil
+	}
+	return fm, next.write(newPath, next.render(values))
+}
+
+func sameFileContents(a, b string) bool {
+	da, err := os.ReadFile(a)
+	if err != nil {
+		return false
+	}
+	db, err := os.ReadFile(b)
+	if err != nil {
+		return false
+	}
+	return string(da) == string(db)
+}
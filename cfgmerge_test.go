@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeCfg(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeInstanceConfigs(t *testing.T) {
+	tests := []struct {
+		name       string
+		base       []string
+		user       []string
+		next       []string
+		want       string
+		kept       []string
+		noBaseline bool
+	}{
+		{
+			name: "three-way keeps an edit the pack did not move",
+			base: []string{"general {", "    I:size=1", "    I:speed=1", "}"},
+			user: []string{"general {", "    I:size=5", "    I:speed=1", "}"},
+			next: []string{"general {", "    I:size=1", "    I:speed=2", "}"},
+			want: "I:size=5\n    I:speed=2",
+			kept: []string{"general/I:size"},
+		},
+		{
+			name:       "no baseline keeps the user's values of keys still there",
+			user:       []string{"general {", "    I:size=5", "    I:gone=1", "}"},
+			next:       []string{"general {", "    I:size=1", "    I:added=3", "}"},
+			want:       "I:size=5\n    I:added=3",
+			kept:       []string{"general/I:size"},
+			noBaseline: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			mp := &configMergePlan{SourceDir: filepath.Join(dir, "user"), BaselineDir: filepath.Join(dir, "base")}
+			writeCfg(t, filepath.Join(mp.SourceDir, "mod.cfg"), tt.user...)
+			if tt.base != nil {
+				writeCfg(t, filepath.Join(mp.BaselineDir, "mod.cfg"), tt.base...)
+			}
+			root := filepath.Join(dir, "new")
+			writeCfg(t, filepath.Join(root, "config", "mod.cfg"), tt.next...)
+
+			res, err := mergeInstanceConfigs(mp, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(filepath.Join(root, "config", "mod.cfg"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), tt.want) {
+				t.Errorf("merged file:\n%s\nwant it to contain %q", data, tt.want)
+			}
+			if len(res.Files) != 1 || !reflect.DeepEqual(res.Files[0].Kept, tt.kept) {
+				t.Errorf("files = %+v, want one keeping %v", res.Files, tt.kept)
+			}
+			if got := len(res.NoBaseline) == 1; got != tt.noBaseline {
+				t.Errorf("noBaseline = %v, want %v", res.NoBaseline, tt.noBaseline)
+			}
+		})
+	}
+}
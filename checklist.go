@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checklist is a multi-select list used by the TUI screens that let the user pick
+// which things to carry over.
+type checklist struct {
+	items  []checkItem
+	cursor int
+}
+
+type checkItem struct {
+	label   string
+	detail  string
+	checked bool
+}
+
+// update handles a key press and reports whether the key was used.
+func (c *checklist) update(key string) bool {
+	switch key {
+	case "up", "k":
+		if c.cursor > 0 {
+			c.cursor--
+		}
+	case "down", "j":
+		if c.cursor < len(c.items)-1 {
+			c.cursor++
+		}
+	case " ", "x":
+		if len(c.items) > 0 {
+			c.items[c.cursor].checked = !c.items[c.cursor].checked
+		}
+	case "a":
+		all := true
+		for _, it := range c.items {
+			all = all && it.checked
+		}
+		for i := range c.items {
+			c.items[i].checked = !all
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func (c checklist) checked() []int {
+	var idx []int
+	for i, it := range c.items {
+		if it.checked {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+func (c checklist) view() string {
+	var b strings.Builder
+	for i, it := range c.items {
+		box := "[ ]"
+		if it.checked {
+			box = "[x]"
+		}
+		line := fmt.Sprintf("%s %s", box, it.label)
+		if it.detail != "" {
+			line += "  " + it.detail
+		}
+		if i == c.cursor {
+			b.WriteString(selectedItemStyle.Render("> "+line) + "\n")
+		} else {
+			b.WriteString(itemStyle.Render(line) + "\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultPruneTicks is how long players must have spent near a chunk, in game
+// ticks, for it to count as visited: one minute.
+const defaultPruneTicks = 20 * 60
+
+// chunkPrunePlan is the optional step that drops unvisited chunks from the copied
+// worlds. The source worlds are never changed.
+type chunkPrunePlan struct {
+	Enabled  bool  `json:"enabled"`
+	MaxTicks int64 `json:"maxTicks"`
+}
+
+func (p *chunkPrunePlan) threshold() string {
+	return (time.Duration(p.MaxTicks) * time.Second / 20).String()
+}
+
+// chunkScan counts a world's chunks and the unvisited ones among them.
+type chunkScan struct {
+	World          string `json:"world"`
+	Regions        int    `json:"regions"`
+	Chunks         int    `json:"chunks"`
+	Unvisited      int    `json:"unvisited"`
+	UnvisitedBytes int64  `json:"unvisitedBytes"`
+	// Unreadable chunks are always kept; their regions are left as they are.
+	Unreadable int `json:"unreadable,omitempty"`
+	// Dropped counts the unvisited chunks actually removed.
+	Dropped      int   `json:"dropped,omitempty"`
+	DroppedBytes int64 `json:"droppedBytes,omitempty"`
+	drop         bool
+}
+
+// scanUnvisitedChunks counts, per world under savesDir, the chunks players spent
+// less than maxTicks near, and removes them when drop is set. skip names worlds
+// to leave alone.
+func scanUnvisitedChunks(ctx context.Context, savesDir string, maxTicks int64, drop bool, skip []string, progress func(done, total int64)) ([]*chunkScan, error) {
+	files, err := regionFiles(savesDir)
+	if err != nil {
+		return nil, err
+	}
+	skipped := make(map[string]bool, len(skip))
+	for _, s := range skip {
+		skipped[s] = true
+	}
+	scans := make(map[string]*chunkScan)
+	var todo []string
+	for _, f := range files {
+		world := regionWorld(savesDir, f)
+		if skipped[world] {
+			continue
+		}
+		if scans[world] == nil {
+			scans[world] = &chunkScan{World: world, drop: drop}
+		}
+		todo = append(todo, f)
+	}
+
+	var mu sync.Mutex
+	err = eachRegion(ctx, todo, progress, func(path string) error {
+		res, err := scanRegion(path, maxTicks, drop)
+		mu.Lock()
+		defer mu.Unlock()
+		s := scans[regionWorld(savesDir, path)]
+		s.Regions++
+		s.Chunks += res.Chunks
+		s.Unvisited += res.Unvisited
+		s.UnvisitedBytes += res.UnvisitedBytes
+		s.Unreadable += res.Unreadable
+		s.Dropped += res.Dropped
+		s.DroppedBytes += res.DroppedBytes
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var out []*chunkScan
+	for _, s := range scans {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].World < out[b].World })
+	return out, nil
+}
+
+// scanRegion counts the chunks of one region file and, when drop is set, rewrites
+// it without the unvisited ones. A region with unreadable chunks is never rewritten.
+func scanRegion(path string, maxTicks int64, drop bool) (chunkScan, error) {
+	var res chunkScan
+	r, err := readRegionFile(path)
+	if errors.Is(err, errRegionName) {
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	if len(r.data) < regionHeaderSize {
+		res.Unreadable++
+		return res, nil
+	}
+	unvisited := make(map[int]bool)
+	for i := 0; i < regionChunks; i++ {
+		c, err := r.chunk(i)
+		if err != nil {
+			res.Chunks++
+			res.Unreadable++
+			continue
+		}
+		if c == nil {
+			continue
+		}
+		res.Chunks++
+		if ticks, ok := inhabitedTime(c); ok && ticks < maxTicks {
+			_, sectors := r.location(i)
+			unvisited[i] = true
+			res.Unvisited++
+			res.UnvisitedBytes += int64(sectors) * regionSectorSize
+		}
+	}
+	if !drop || len(unvisited) == 0 || res.Unreadable > 0 {
+		return res, nil
+	}
+	if err := r.rewrite(func(i int) bool { return !unvisited[i] }); err != nil {
+		return res, err
+	}
+	res.Dropped, res.DroppedBytes = res.Unvisited, res.UnvisitedBytes
+	return res, nil
+}
+
+func (s *chunkScan) describe() string {
+	line := fmt.Sprintf("%s: %d regions, %d chunks, %d unvisited (%s)", s.World, s.Regions, s.Chunks, s.Unvisited, formatBytes(s.UnvisitedBytes))
+	if s.drop {
+		line += fmt.Sprintf(", %d dropped (%s)", s.Dropped, formatBytes(s.DroppedBytes))
+	}
+	if s.Unreadable > 0 {
+		line += fmt.Sprintf(", %d unreadable chunks or region headers, their regions left as they are", s.Unreadable)
+	}
+	return line
+}
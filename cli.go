@@ -0,0 +1,769 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type cliOptions struct {
+	source   string
+	dest     string
+	version  string
+	dryRun   bool
+	jsonOut  bool
+	userMods string
+	modes    string
+	conflict string
+	worlds   string
+	noVerify bool
+	noBackup bool
+	inPlace  bool
+	yes      bool
+
+	pruneChunks   bool
+	noRegionCheck bool
+	dereference   bool
+	exclude       string
+	// flagged is set when any migration flag was given, even one left at its default.
+	flagged bool
+
+	// command names the subcommand, empty for a migration.
+	command    string
+	instance   string
+	list       bool
+	rollbackID string
+
+	// restore subcommand
+	world string
+	force bool
+
+	// chunks subcommand
+	ticks int64
+
+	// snapshots subcommand
+	backupDir   string
+	check       bool
+	snapshotID  string
+	restoreTo   string
+	restorePath string
+}
+
+// parseCLI parses the command-line flags. It returns flag.ErrHelp when usage was requested.
+func parseCLI(args []string, output io.Writer) (*cliOptions, error) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "rollback":
+			return parseRollbackCLI(args[1:], output)
+		case "prune":
+			return parsePruneCLI(args[1:], output)
+		case "snapshots":
+			return parseSnapshotsCLI(args[1:], output)
+		case "restore":
+			return parseRestoreCLI(args[1:], output)
+		case "chunks":
+			return parseChunksCLI(args[1:], output)
+		}
+	}
+	opts := &cliOptions{}
+	fs := flag.NewFlagSet("gtnh-updater-cli", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.source, "source", "", "instance to migrate from (path, or folder name under the saved instances dir)")
+	fs.StringVar(&opts.dest, "dest", "", "new instance to create (path, or folder name under the saved instances dir)")
+	fs.StringVar(&opts.version, "version", "", "GTNH release to install (defaults to the last selected one)")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print the migration plan without changing anything")
+	fs.BoolVar(&opts.jsonOut, "json", false, "print the dry-run plan as JSON")
+	fs.StringVar(&opts.modes, "mode", "", "transfer mode per folder, e.g. saves=reflink,screenshots=move (copy, hardlink, reflink or move)")
+	fs.StringVar(&opts.conflict, "conflict", "", "what to do with files the new pack ships too, per folder or file, e.g. serverutilities/serverutilities.cfg=keep-both (overwrite, keep-new, keep-both or merge)")
+	fs.StringVar(&opts.worlds, "worlds", "", "worlds to migrate, comma-separated folder names, or \"all\" (remembered per source; defaults to the last choice)")
+	fs.BoolVar(&opts.inPlace, "in-place", false, "upgrade the source instance itself instead of creating a new one")
+	fs.BoolVar(&opts.yes, "yes", false, "confirm an in-place upgrade")
+	fs.BoolVar(&opts.noBackup, "no-backup", false, "do not back up the worlds before migrating; an in-place upgrade always backs them up")
+	fs.BoolVar(&opts.noVerify, "no-verify", false, "skip comparing the copied files with their source")
+	fs.BoolVar(&opts.noRegionCheck, "no-region-check", false, "skip reading every chunk of the worlds before and after the migration")
+	fs.BoolVar(&opts.pruneChunks, "prune-chunks", false, "drop chunks players barely visited from the copied worlds (the source keeps them)")
+	fs.BoolVar(&opts.dereference, "dereference", false, "copy what symbolic links in the user data point to instead of recreating the links")
+	fs.StringVar(&opts.exclude, "exclude", "", "leave out files inside the migrated folders, comma-separated globs relative to the game folder with an optional age, e.g. backups/*=30d,journeymap/data/mp/*")
+	fs.StringVar(&opts.userMods, "user-mods", "none", "copy your own mods to the new instance: none, recommended (skips ones the pack already bundles) or all")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gtnh-updater-cli [flags]")
+		fmt.Fprintln(fs.Output(), "       gtnh-updater-cli rollback [-instance path] [-list] [id]")
+		fmt.Fprintln(fs.Output(), "       gtnh-updater-cli prune [-instance path] [-dry-run]")
+		fmt.Fprintln(fs.Output(), "       gtnh-updater-cli snapshots [-dir path] [-check] [-restore id -to path [-path saves/world]]")
+		fmt.Fprintln(fs.Output(), "       gtnh-updater-cli restore [-instance path] [-dir path] [-world name] [-force] [-list] [id]")
+		fmt.Fprintln(fs.Output(), "       gtnh-updater-cli chunks [-instance path] [-ticks n] [-check]")
+		fmt.Fprintln(fs.Output(), "Without flags the interactive interface starts.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		err := fmt.Errorf("unexpected argument %q", fs.Arg(0))
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return nil, err
+	}
+	opts.flagged = fs.NFlag() > 0
+	return opts, nil
+}
+
+func parseRollbackCLI(args []string, output io.Writer) (*cliOptions, error) {
+	opts := &cliOptions{command: "rollback"}
+	fs := flag.NewFlagSet("gtnh-updater-cli rollback", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.instance, "instance", "", "instance to roll back (path, or folder name under the saved instances dir; defaults to the last selected one)")
+	fs.BoolVar(&opts.list, "list", false, "list the available rollbacks instead of applying one")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gtnh-updater-cli rollback [-instance path] [-list] [id]")
+		fmt.Fprintln(fs.Output(), "Undoes the newest in-place upgrade, or every upgrade back to and including id.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 1 {
+		err := fmt.Errorf("unexpected argument %q", fs.Arg(1))
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return nil, err
+	}
+	opts.rollbackID = fs.Arg(0)
+	return opts, nil
+}
+
+func parsePruneCLI(args []string, output io.Writer) (*cliOptions, error) {
+	opts := &cliOptions{command: "prune"}
+	fs := flag.NewFlagSet("gtnh-updater-cli prune", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.instance, "instance", "", "instance whose backups folder to prune (path, or folder name under the saved instances dir; defaults to the last selected one)")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "list what would be removed without removing it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gtnh-updater-cli prune [-instance path] [-dry-run]")
+		fmt.Fprintln(fs.Output(), "Applies the retention policy from the config to the world backups and the instance's own backups folder.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		err := fmt.Errorf("unexpected argument %q", fs.Arg(0))
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return nil, err
+	}
+	return opts, nil
+}
+
+func parseRestoreCLI(args []string, output io.Writer) (*cliOptions, error) {
+	opts := &cliOptions{command: "restore"}
+	fs := flag.NewFlagSet("gtnh-updater-cli restore", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.instance, "instance", "", "instance to restore into (path, or folder name under the saved instances dir; defaults to the last selected one)")
+	fs.StringVar(&opts.backupDir, "dir", "", "backup folder to restore from (defaults to the configured one)")
+	fs.StringVar(&opts.world, "world", "", "restore only this world of the backup")
+	fs.BoolVar(&opts.force, "force", false, "overwrite worlds that changed after the backup was taken")
+	fs.BoolVar(&opts.list, "list", false, "list the available backups instead of restoring one")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gtnh-updater-cli restore [-instance path] [-dir path] [-world name] [-force] [-list] [id]")
+		fmt.Fprintln(fs.Output(), "Restores backup id, or the newest backup taken from the instance, into the instance.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 1 {
+		err := fmt.Errorf("unexpected argument %q", fs.Arg(1))
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return nil, err
+	}
+	opts.snapshotID = fs.Arg(0)
+	return opts, nil
+}
+
+func parseChunksCLI(args []string, output io.Writer) (*cliOptions, error) {
+	opts := &cliOptions{command: "chunks"}
+	fs := flag.NewFlagSet("gtnh-updater-cli chunks", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.instance, "instance", "", "instance whose worlds to scan (path, or folder name under the saved instances dir; defaults to the last selected one)")
+	fs.Int64Var(&opts.ticks, "ticks", 0, "chunks players spent fewer ticks than this near count as unvisited (defaults to the config, or 1200)")
+	fs.BoolVar(&opts.check, "check", false, "read every chunk and list the damaged ones instead")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gtnh-updater-cli chunks [-instance path] [-ticks n] [-check]")
+		fmt.Fprintln(fs.Output(), "Counts the unvisited chunks in the instance's worlds, or checks them for damage, without changing them.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		err := fmt.Errorf("unexpected argument %q", fs.Arg(0))
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return nil, err
+	}
+	if opts.ticks < 0 {
+		err := errors.New("-ticks must not be negative")
+		fmt.Fprintln(fs.Output(), err)
+		return nil, err
+	}
+	return opts, nil
+}
+
+func parseSnapshotsCLI(args []string, output io.Writer) (*cliOptions, error) {
+	opts := &cliOptions{command: "snapshots"}
+	fs := flag.NewFlagSet("gtnh-updater-cli snapshots", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.instance, "instance", "", "instance whose backup folder to use when -dir and the config do not name one")
+	fs.StringVar(&opts.backupDir, "dir", "", "backup folder holding the store (defaults to the configured one)")
+	fs.BoolVar(&opts.check, "check", false, "verify every chunk the snapshots use")
+	fs.StringVar(&opts.snapshotID, "restore", "", "snapshot to restore")
+	fs.StringVar(&opts.restoreTo, "to", "", "empty or new folder to restore the snapshot into")
+	fs.StringVar(&opts.restorePath, "path", "", "restore only this file or folder of the snapshot, e.g. saves/World")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gtnh-updater-cli snapshots [-dir path] [-check] [-restore id -to path [-path saves/world]]")
+		fmt.Fprintln(fs.Output(), "Lists, checks or restores the snapshots in the backup store. Works offline.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		err := fmt.Errorf("unexpected argument %q", fs.Arg(0))
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return nil, err
+	}
+	if (opts.snapshotID == "") != (opts.restoreTo == "") {
+		err := errors.New("-restore and -to go together")
+		fmt.Fprintln(fs.Output(), err)
+		return nil, err
+	}
+	return opts, nil
+}
+
+// headless reports whether the arguments ask for a run without the interactive
+// interface: a subcommand, or any flag at all, so a flag such as -no-backup is
+// never dropped by starting the TUI.
+func (o *cliOptions) headless() bool {
+	return o.command != "" || o.flagged
+}
+
+func runHeadless(opts *cliOptions, stdout io.Writer) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg == nil {
+		cfg = &config{}
+	}
+	switch opts.command {
+	case "rollback":
+		return runRollback(opts, cfg, stdout)
+	case "prune":
+		return runPrune(opts, cfg, stdout)
+	case "snapshots":
+		return runSnapshots(opts, cfg, stdout)
+	case "restore":
+		return runRestore(opts, cfg, stdout)
+	case "chunks":
+		return runChunks(opts, cfg, stdout)
+	}
+	if opts.jsonOut && !opts.dryRun {
+		return errors.New("-json only applies to -dry-run")
+	}
+
+	source := opts.source
+	if source == "" && cfg.InstancesDir != "" && cfg.InstanceName != "" {
+		source = filepath.Join(cfg.InstancesDir, cfg.InstanceName)
+	}
+	source, err = resolveInstancePath(source, cfg.InstancesDir)
+	if err != nil {
+		return fmt.Errorf("-source: %w", err)
+	}
+	version := opts.version
+	if version == "" {
+		version = cfg.SelectedVersion
+	}
+
+	var plan *migrationPlan
+	if opts.inPlace {
+		if opts.dest != "" {
+			return errors.New("-dest does not apply to -in-place")
+		}
+		plan, err = buildUpgradePlan(source, version)
+	} else {
+		dest, derr := resolveInstancePath(opts.dest, cfg.InstancesDir)
+		if derr != nil {
+			return fmt.Errorf("-dest: %w", derr)
+		}
+		plan, err = buildMigrationPlan(source, dest, version)
+	}
+	if err != nil {
+		return err
+	}
+	if err := applyModeFlag(plan, opts.modes); err != nil {
+		return fmt.Errorf("-mode: %w", err)
+	}
+	if err := applyConflictFlag(plan, opts.conflict); err != nil {
+		return fmt.Errorf("-conflict: %w", err)
+	}
+	if opts.worlds != "" {
+		if plan.InPlace {
+			return errors.New("-worlds does not apply to -in-place")
+		}
+		if err := applyWorldsFlag(plan, opts.worlds); err != nil {
+			return fmt.Errorf("-worlds: %w", err)
+		}
+	}
+	if opts.noVerify {
+		plan.Verify = false
+	}
+	if opts.noRegionCheck {
+		plan.CheckRegions = false
+	}
+	if opts.pruneChunks {
+		if plan.InPlace {
+			return errors.New("-prune-chunks does not apply to -in-place")
+		}
+		if plan.ChunkPrune == nil {
+			return errors.New("-prune-chunks: the source has no saves folder to migrate")
+		}
+		plan.ChunkPrune.Enabled = true
+	}
+	if opts.dereference {
+		if plan.InPlace {
+			return errors.New("-dereference does not apply to -in-place")
+		}
+		if err := plan.setDereference(true); err != nil {
+			return fmt.Errorf("-dereference: %w", err)
+		}
+	}
+	if opts.exclude != "" {
+		if plan.InPlace {
+			return errors.New("-exclude does not apply to -in-place")
+		}
+		if err := applyExcludeFlag(plan, opts.exclude); err != nil {
+			return fmt.Errorf("-exclude: %w", err)
+		}
+	}
+	if opts.noBackup && plan.InPlace {
+		return errors.New("-no-backup does not apply to -in-place; the worlds are always backed up before an upgrade")
+	}
+	if opts.noBackup && plan.Backup != nil {
+		plan.Backup.Enabled = false
+	}
+	switch opts.userMods {
+	case "none", "recommended", "all":
+	default:
+		return fmt.Errorf("-user-mods must be none, recommended or all, not %q", opts.userMods)
+	}
+
+	if opts.dryRun {
+		if opts.jsonOut {
+			enc := json.NewEncoder(stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(plan)
+		}
+		plan.writeText(stdout)
+		return nil
+	}
+	if plan.asksAboutConflicts() {
+		return errors.New("a conflict policy is ask, which needs the interactive interface; pick another with -conflict")
+	}
+
+	if plan.InPlace {
+		if !opts.yes {
+			return fmt.Errorf("an in-place upgrade replaces the pack folders of %s; review it with -dry-run and pass -yes to go ahead", plan.Destination)
+		}
+		fmt.Fprintf(stdout, "Upgrading %s in place...\n", plan.Destination)
+	} else {
+		fmt.Fprintf(stdout, "Migrating %s to %s...\n", plan.Source, plan.Destination)
+		if opts.worlds != "" {
+			if err := saveSkippedWorlds(plan); err != nil {
+				return fmt.Errorf("remember the worlds: %w", err)
+			}
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := executeMigration(ctx, plan, nil, nil)
+	if err != nil {
+		return err
+	}
+	if md := report.Mods; md != nil && opts.userMods != "none" {
+		var chosen []userMod
+		for _, um := range md.UserMods {
+			if opts.userMods == "all" || um.recommended() {
+				chosen = append(chosen, um)
+			}
+		}
+		md.Copied, err = copyUserMods(chosen, plan.Destination, plan.DestRoot)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(stdout, completionMessage(plan, report))
+	return nil
+}
+
+// subcommandInstance resolves the -instance flag of a subcommand, defaulting to
+// the last selected instance.
+func subcommandInstance(opts *cliOptions, cfg *config) (string, error) {
+	instance := opts.instance
+	if instance == "" && cfg.InstancesDir != "" && cfg.InstanceName != "" {
+		instance = filepath.Join(cfg.InstancesDir, cfg.InstanceName)
+	}
+	instance, err := resolveInstancePath(instance, cfg.InstancesDir)
+	if err != nil {
+		return "", fmt.Errorf("-instance: %w", err)
+	}
+	return instance, nil
+}
+
+func runPrune(opts *cliOptions, cfg *config, stdout io.Writer) error {
+	if !cfg.Retention.active() {
+		path, _ := getConfigPath()
+		return fmt.Errorf("no retention policy set; add a \"retention\" section to %s", path)
+	}
+	instance, err := subcommandInstance(opts, cfg)
+	if err != nil {
+		return err
+	}
+	backupDir := configuredBackupDir(cfg, instance)
+	archives, err := listBackupArchives(backupDir)
+	if err != nil {
+		return err
+	}
+	dropped, err := pruneBackups(archives, cfg.Retention, opts.dryRun)
+	if storeDir := backupStoreDir(backupDir); err == nil && pathExists(storeDir) {
+		var (
+			store *backupStore
+			more  []backupItem
+		)
+		if store, err = openBackupStore(storeDir); err == nil {
+			more, err = store.prune("", cfg.Retention, opts.dryRun)
+			dropped = append(dropped, more...)
+		}
+	}
+	if err == nil {
+		var more []backupItem
+		more, err = pruneRollbacks(instance, rollbackRetention(cfg.Retention), opts.dryRun)
+		dropped = append(dropped, more...)
+	}
+	if err == nil {
+		var inGame map[string][]backupItem
+		if inGame, err = listInGameBackups(filepath.Join(destinationRoot(instance, pathExists), "backups")); err == nil {
+			var more []backupItem
+			more, err = pruneBackups(inGame, cfg.Retention, opts.dryRun)
+			dropped = append(dropped, more...)
+		}
+	}
+	verb := "Removed"
+	if opts.dryRun {
+		verb = "Would remove"
+	}
+	var total int64
+	for _, it := range dropped {
+		fmt.Fprintf(stdout, "%s %s (%s)\n", verb, it.Path, formatBytes(it.Size))
+		total += it.Size
+	}
+	fmt.Fprintf(stdout, "%s %d backups, %s\n", verb, len(dropped), formatBytes(total))
+	return err
+}
+
+// applyWorldsFlag migrates only the listed worlds.
+func applyWorldsFlag(plan *migrationPlan, value string) error {
+	keep := make(map[string]bool)
+	if value != "all" {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				keep[name] = true
+			}
+		}
+	}
+	var skip []string
+	for _, w := range plan.Worlds {
+		if value != "all" && !keep[w.Folder] {
+			skip = append(skip, w.Folder)
+		}
+		delete(keep, w.Folder)
+	}
+	for name := range keep {
+		return fmt.Errorf("no world %q in %s", name, plan.Source)
+	}
+	return plan.skipWorlds(skip)
+}
+
+func runSnapshots(opts *cliOptions, cfg *config, stdout io.Writer) error {
+	backupDir := opts.backupDir
+	if backupDir == "" {
+		backupDir = cfg.BackupDir
+	}
+	if backupDir == "" {
+		instance, err := subcommandInstance(opts, cfg)
+		if err != nil {
+			return err
+		}
+		backupDir = defaultBackupDir(instance)
+	}
+	storeDir := backupStoreDir(backupDir)
+	if !pathExists(storeDir) {
+		return fmt.Errorf("no backup store in %s", backupDir)
+	}
+	store, err := openBackupStore(storeDir)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch {
+	case opts.snapshotID != "":
+		snap, err := store.snapshot(opts.snapshotID)
+		if os.IsNotExist(err) {
+			return errors.New("snapshot not found: " + opts.snapshotID)
+		}
+		if err != nil {
+			return err
+		}
+		if entries, err := os.ReadDir(opts.restoreTo); err == nil && len(entries) > 0 {
+			return fmt.Errorf("%s is not empty", opts.restoreTo)
+		}
+		prefix := ""
+		if opts.restorePath != "" {
+			prefix = filepath.ToSlash(filepath.Clean(opts.restorePath))
+		}
+		if err := store.restore(ctx, snap, prefix, opts.restoreTo, nil); err != nil {
+			return fmt.Errorf("restore %s: %w", snap.ID, err)
+		}
+		fmt.Fprintf(stdout, "Restored %s into %s\n", snap.ID, opts.restoreTo)
+		return nil
+	case opts.check:
+		res, err := store.check(ctx, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Checked %d snapshots, %d chunks\n", res.Snapshots, res.Chunks)
+		if len(res.Damaged) == 0 {
+			fmt.Fprintln(stdout, "All snapshots can be restored")
+			return nil
+		}
+		ids := make([]string, 0, len(res.Damaged))
+		for id := range res.Damaged {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			fmt.Fprintf(stdout, "%s: %d damaged files\n", id, len(res.Damaged[id]))
+			for _, p := range res.Damaged[id] {
+				fmt.Fprintf(stdout, "  %s\n", p)
+			}
+		}
+		return fmt.Errorf("%d snapshots have missing or corrupt chunks", len(res.Damaged))
+	}
+
+	snaps, err := store.snapshots()
+	if err != nil {
+		return err
+	}
+	if len(snaps) == 0 {
+		fmt.Fprintf(stdout, "No snapshots in %s\n", storeDir)
+		return nil
+	}
+	for _, snap := range snaps {
+		fmt.Fprintln(stdout, snap.describe())
+	}
+	return nil
+}
+
+func runRestore(opts *cliOptions, cfg *config, stdout io.Writer) error {
+	instance, err := subcommandInstance(opts, cfg)
+	if err != nil {
+		return err
+	}
+	backupDir := opts.backupDir
+	if backupDir == "" {
+		backupDir = configuredBackupDir(cfg, instance)
+	}
+	backups, err := listAvailableBackups(backupDir)
+	if err != nil {
+		return err
+	}
+	if opts.list {
+		if len(backups) == 0 {
+			fmt.Fprintf(stdout, "No backups in %s\n", backupDir)
+			return nil
+		}
+		for _, b := range backups {
+			fmt.Fprintln(stdout, b.describe())
+		}
+		return nil
+	}
+	b, err := findBackup(backups, opts.snapshotID, instance)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := restoreBackup(ctx, b, opts.world, instance, opts.force)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Restored %s from %s into %s\n", strings.Join(res.Restored, ", "), res.Backup, res.Target)
+	return nil
+}
+
+func runChunks(opts *cliOptions, cfg *config, stdout io.Writer) error {
+	instance, err := subcommandInstance(opts, cfg)
+	if err != nil {
+		return err
+	}
+	ticks := opts.ticks
+	if ticks == 0 {
+		ticks = cfg.pruneTicks()
+	}
+	savesDir := filepath.Join(destinationRoot(instance, pathExists), "saves")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if opts.check {
+		res, err := checkRegions(ctx, savesDir, nil, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s: %s\n", savesDir, res.describe())
+		for _, p := range res.Problems {
+			fmt.Fprintln(stdout, p.describe())
+		}
+		if len(res.Problems) > 0 {
+			return fmt.Errorf("%d damaged chunks", len(res.Problems))
+		}
+		return nil
+	}
+	scans, err := scanUnvisitedChunks(ctx, savesDir, ticks, false, nil, nil)
+	if err != nil {
+		return err
+	}
+	if len(scans) == 0 {
+		fmt.Fprintf(stdout, "No region files in %s\n", savesDir)
+		return nil
+	}
+	fmt.Fprintf(stdout, "Chunks players spent less than %s near:\n", (&chunkPrunePlan{MaxTicks: ticks}).threshold())
+	for _, s := range scans {
+		fmt.Fprintln(stdout, s.describe())
+	}
+	return nil
+}
+
+func runRollback(opts *cliOptions, cfg *config, stdout io.Writer) error {
+	instance, err := subcommandInstance(opts, cfg)
+	if err != nil {
+		return err
+	}
+	if opts.list {
+		journals, err := listRollbacks(instance)
+		if err != nil {
+			return err
+		}
+		if len(journals) == 0 {
+			fmt.Fprintf(stdout, "No rollbacks available for %s\n", instance)
+			return nil
+		}
+		for _, j := range journals {
+			fmt.Fprintln(stdout, j.describe())
+		}
+		return nil
+	}
+	undone, err := rollbackInstance(instance, opts.rollbackID)
+	for _, j := range undone {
+		fmt.Fprintf(stdout, "Rolled back %s (%s)\n", j.ID, j.Release)
+	}
+	return err
+}
+
+// applyModeFlag applies a folder=mode list to the plan.
+func applyModeFlag(plan *migrationPlan, spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		folder, modeName, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("want folder=mode, got %q", part)
+		}
+		mode, err := parseTransferMode(modeName)
+		if err != nil {
+			return err
+		}
+		if err := plan.setMode(strings.TrimSpace(folder), mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyConflictFlag sets the conflict policies given as entry=policy pairs.
+func applyConflictFlag(plan *migrationPlan, spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		entry, name, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("want folder=policy, got %q", part)
+		}
+		policy, err := parseConflictPolicy(name)
+		if err != nil {
+			return err
+		}
+		if policy == conflictAsk {
+			return errors.New("ask needs the interactive interface")
+		}
+		if err := plan.setConflict(strings.TrimSpace(entry), policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyExcludeFlag(plan *migrationPlan, spec string) error {
+	var rules []exclusionRule
+	for _, part := range strings.Split(spec, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		r, err := parseExclusionRule(part)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, r)
+	}
+	return plan.addExclusions(rules)
+}
+
+// resolveInstancePath accepts either a path or a bare folder name, which is looked
+// up under the saved instances directory.
+func resolveInstancePath(p, instancesDir string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return "", errors.New("no instance given")
+	}
+	if filepath.IsAbs(p) || strings.ContainsAny(p, "\\/") {
+		return filepath.Clean(p), nil
+	}
+	if instancesDir == "" {
+		return "", fmt.Errorf("%q is not a path and no instances directory is saved", p)
+	}
+	return filepath.Join(instancesDir, p), nil
+}
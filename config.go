@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 )
@@ -10,6 +11,158 @@ type config struct {
 	InstancesDir    string `json:"instancesDir"`
 	InstanceName    string `json:"instanceName"`
 	SelectedVersion string `json:"selectedVersion"`
+	// TransferModes holds the preferred transfer mode per migrated folder, e.g. "saves": "reflink".
+	TransferModes map[string]transferMode `json:"transferModes,omitempty"`
+	// SkipVerification turns off the post-copy comparison by default.
+	SkipVerification bool `json:"skipVerification,omitempty"`
+	// BackupDir is where world backups go; empty means a gtnh-backups folder next
+	// to the instances folder.
+	BackupDir string `json:"backupDir,omitempty"`
+	// BackupExtras are more folders of the game folder to back up with the saves,
+	// e.g. "journeymap".
+	BackupExtras []string `json:"backupExtras,omitempty"`
+	SkipBackup   bool     `json:"skipBackup,omitempty"`
+	// BackupFormat is "store" (the default, incremental and deduplicated) or "zip".
+	BackupFormat string `json:"backupFormat,omitempty"`
+	// Retention rotates the world backups and the game's own backups folder.
+	Retention *retentionPolicy `json:"retention,omitempty"`
+	// SkippedWorlds holds, per source instance, the worlds left out of the last
+	// migration. Worlds created since are migrated.
+	SkippedWorlds map[string][]string `json:"skippedWorlds,omitempty"`
+	// ExcludedEntries are the folders and files of the migration lists that are
+	// left behind unless picked again, e.g. "screenshots".
+	ExcludedEntries []string `json:"excludedEntries,omitempty"`
+	// PruneChunks drops chunks players barely visited from the migrated worlds;
+	// PruneChunkTicks is how many ticks count as barely (default one minute).
+	PruneChunks     bool  `json:"pruneChunks,omitempty"`
+	PruneChunkTicks int64 `json:"pruneChunkTicks,omitempty"`
+	// ConflictPolicies says, per migrated folder or file, what to do with files
+	// the new pack ships too, e.g. "serverutilities/serverutilities.cfg": "keep-both".
+	ConflictPolicies map[string]conflictPolicy `json:"conflictPolicies,omitempty"`
+	// DereferenceSymlinks copies what symbolic links in the user data point to
+	// instead of recreating the links.
+	DereferenceSymlinks bool `json:"dereferenceSymlinks,omitempty"`
+	// ExclusionRules leave files inside the migrated folders behind, on top of
+	// the operating systems' junk files, e.g. {"pattern": "backups/*", "olderThan": "30d"}.
+	ExclusionRules []exclusionRule `json:"exclusionRules,omitempty"`
+}
+
+// saveTransferModes remembers the plan's folder modes as the defaults for next time.
+func saveTransferModes(plan *migrationPlan) error {
+	return updateConfig(func(cfg *config) {
+		if cfg.TransferModes == nil {
+			cfg.TransferModes = make(map[string]transferMode)
+		}
+		for _, e := range plan.dirEntries() {
+			cfg.TransferModes[e.Path] = e.Mode
+		}
+	})
+}
+
+// saveSkippedWorlds remembers which worlds of the plan's source were left out.
+func saveSkippedWorlds(plan *migrationPlan) error {
+	return updateConfig(func(cfg *config) {
+		if cfg.SkippedWorlds == nil {
+			cfg.SkippedWorlds = make(map[string][]string)
+		}
+		if skipped := plan.skippedWorlds(); len(skipped) > 0 {
+			cfg.SkippedWorlds[instanceKey(plan.Source)] = skipped
+		} else {
+			delete(cfg.SkippedWorlds, instanceKey(plan.Source))
+		}
+	})
+}
+
+// instanceKey names an instance in per-instance config maps, however it was given.
+func instanceKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// saveExcludedEntries makes the plan's left-out entries the default for next time.
+func saveExcludedEntries(plan *migrationPlan) error {
+	return updateConfig(func(cfg *config) {
+		// entries this source does not have keep their default
+		var excluded []string
+		for _, path := range cfg.ExcludedEntries {
+			found := false
+			for _, e := range plan.Entries {
+				found = found || filepath.ToSlash(e.Path) == path
+			}
+			if !found {
+				excluded = append(excluded, path)
+			}
+		}
+		for _, e := range plan.Entries {
+			if e.Excluded {
+				excluded = append(excluded, filepath.ToSlash(e.Path))
+			}
+		}
+		cfg.ExcludedEntries = excluded
+	})
+}
+
+func (c *config) pruneTicks() int64 {
+	if c == nil || c.PruneChunkTicks <= 0 {
+		return defaultPruneTicks
+	}
+	return c.PruneChunkTicks
+}
+
+func (c *config) excluded(entry string) bool {
+	if c == nil {
+		return false
+	}
+	for _, e := range c.ExcludedEntries {
+		if filepath.FromSlash(e) == entry {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *config) transferMode(entry string) transferMode {
+	if c != nil {
+		if m, ok := c.TransferModes[entry]; ok {
+			return m
+		}
+	}
+	return modeCopy
+}
+
+// conflictPolicy is the configured conflict policy of entry, or its default.
+func (c *config) conflictPolicy(entry string) (conflictPolicy, error) {
+	if c != nil {
+		if p, ok := c.ConflictPolicies[filepath.ToSlash(entry)]; ok {
+			policy, err := parseConflictPolicy(string(p))
+			if err != nil {
+				return "", fmt.Errorf("conflictPolicies: %s: %w", filepath.ToSlash(entry), err)
+			}
+			if err := checkConflictPolicy(entry, policy); err != nil {
+				return "", fmt.Errorf("conflictPolicies: %w", err)
+			}
+			return policy, nil
+		}
+	}
+	return defaultConflictPolicy(entry), nil
+}
+
+// exclusions are the junk files plus the configured exclusion rules.
+func (c *config) exclusions() []exclusionRule {
+	rules := append([]exclusionRule{}, junkExclusions...)
+	if c != nil {
+		rules = append(rules, c.ExclusionRules...)
+	}
+	return rules
+}
+
+func (c *config) retention() *retentionPolicy {
+	if c == nil {
+		return nil
+	}
+	return c.Retention
 }
 
 func getConfigPath() (string, error) {
@@ -21,23 +174,43 @@ func getConfigPath() (string, error) {
 	return filepath.Join(appDir, "config.json"), nil
 }
 
+// loadConfig reads the config file; it returns nil and no error when there is
+// none yet. A file that cannot be read or decoded is an error, so callers neither
+// run with defaults the user did not choose nor save over it.
 func loadConfig() (*config, error) {
 	path, err := getConfigPath()
 	if err != nil {
 		return nil, err
 	}
 	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("read config %s: %w", path, err)
 	}
 	defer f.Close()
 	var cfg config
 	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("read config %s: %w; fix or remove the file", path, err)
 	}
 	return &cfg, nil
 }
 
+// updateConfig loads the config, lets change edit it and saves it, so a change
+// to one setting leaves the others as they were.
+func updateConfig(change func(cfg *config)) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg == nil {
+		cfg = &config{}
+	}
+	change(cfg)
+	return saveConfig(cfg)
+}
+
 func saveConfig(cfg *config) error {
 	path, err := getConfigPath()
 	if err != nil {
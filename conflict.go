@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// conflictPolicy says what happens when the new pack already has a file the
+// migration would carry over.
+type conflictPolicy string
+
+const (
+	// conflictOverwrite replaces the new pack's file with the user's.
+	conflictOverwrite conflictPolicy = "overwrite"
+	// conflictKeepNew leaves the new pack's file and drops the user's.
+	conflictKeepNew conflictPolicy = "keep-new"
+	// conflictKeepBoth leaves the new pack's file and puts the user's next to it
+	// under a suffixed name.
+	conflictKeepBoth conflictPolicy = "keep-both"
+	// conflictMerge merges the user's file into the new pack's, for files that
+	// have a merger.
+	conflictMerge conflictPolicy = "merge"
+	// conflictAsk asks the user about every conflict.
+	conflictAsk conflictPolicy = "ask"
+)
+
+var conflictPolicies = []conflictPolicy{conflictOverwrite, conflictKeepNew, conflictKeepBoth, conflictMerge, conflictAsk}
+
+func parseConflictPolicy(s string) (conflictPolicy, error) {
+	for _, p := range conflictPolicies {
+		if string(p) == strings.ToLower(strings.TrimSpace(s)) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown conflict policy %q (want overwrite, keep-new, keep-both, merge or ask)", s)
+}
+
+// defaultConflictPolicy merges the files that have a merger and overwrites the rest.
+func defaultConflictPolicy(entry string) conflictPolicy {
+	if _, ok := fileMergers[entry]; ok {
+		return conflictMerge
+	}
+	return conflictOverwrite
+}
+
+// checkConflictPolicy rejects a merge for an entry without a merger.
+func checkConflictPolicy(entry string, p conflictPolicy) error {
+	if _, ok := fileMergers[entry]; p == conflictMerge && !ok {
+		return fmt.Errorf("%s cannot be merged; use overwrite, keep-new, keep-both or ask", filepath.ToSlash(entry))
+	}
+	return nil
+}
+
+// setConflict changes the conflict policy of the entry at path.
+func (p *migrationPlan) setConflict(path string, policy conflictPolicy) error {
+	for i, e := range p.Entries {
+		if filepath.ToSlash(e.Path) != path {
+			continue
+		}
+		if err := checkConflictPolicy(e.Path, policy); err != nil {
+			return err
+		}
+		p.Entries[i].Conflict = policy
+		p.Entries[i].Action = actionCopy
+		if policy == conflictMerge {
+			p.Entries[i].Action = actionMerge
+		}
+		return nil
+	}
+	return fmt.Errorf("%s is not in the migration plan", path)
+}
+
+// asksAboutConflicts reports whether a carried-over entry leaves conflicts to the user.
+func (p *migrationPlan) asksAboutConflicts() bool {
+	for _, e := range p.Entries {
+		if !e.Excluded && e.Conflict == conflictAsk {
+			return true
+		}
+	}
+	return false
+}
+
+func conflictNote(p conflictPolicy) string {
+	switch p {
+	case conflictKeepNew:
+		return " (the new pack's files win)"
+	case conflictKeepBoth:
+		return " (yours kept beside the new pack's files)"
+	case conflictAsk:
+		return " (asks when the new pack has the file)"
+	}
+	return ""
+}
+
+// fileConflict is a file the migration would carry over that the new pack
+// already has.
+type fileConflict struct {
+	Entry string
+	// Path is the file relative to the game folder, slash-separated.
+	Path     string
+	CanMerge bool
+}
+
+// conflictAnswer settles one conflict; All settles the rest of the entry's the
+// same way.
+type conflictAnswer struct {
+	Policy conflictPolicy
+	All    bool
+}
+
+// conflictAsker puts a conflict to the user and waits for the answer.
+type conflictAsker func(ctx context.Context, c fileConflict) (conflictAnswer, error)
+
+// conflictOutcome records how a conflict was settled.
+type conflictOutcome struct {
+	Path   string         `json:"path"`
+	Policy conflictPolicy `json:"policy"`
+	// KeptAs is where the user's file went under keep-both.
+	KeptAs string `json:"keptAs,omitempty"`
+}
+
+// keepBothName is a free name beside dst for the user's copy of a file:
+// serverutilities.cfg becomes serverutilities.old.cfg, then serverutilities.old2.cfg.
+// A name is free when it is neither on disk nor in taken, the destinations of the
+// jobs still to run.
+func keepBothName(dst string, taken map[string]bool) string {
+	ext := filepath.Ext(dst)
+	base := strings.TrimSuffix(dst, ext)
+	name := base + ".old" + ext
+	for n := 2; pathExists(name) || taken[name]; n++ {
+		name = fmt.Sprintf("%s.old%d%s", base, n, ext)
+	}
+	return name
+}
+
+// resolveConflicts settles every queued file the new pack already has, asking
+// about those whose entry's policy is ask. Merges run straight away; the other
+// outcomes change or drop the file's job before the engine runs.
+func resolveConflicts(ctx context.Context, plan *migrationPlan, engine *copyEngine, ask conflictAsker, report *migrationReport) error {
+	policies := make(map[string]conflictPolicy, len(plan.Entries))
+	for _, e := range plan.Entries {
+		policies[e.Path] = e.Conflict
+	}
+	answered := make(map[string]conflictPolicy)
+	taken := make(map[string]bool, len(engine.jobs))
+	for _, job := range engine.jobs {
+		taken[job.dst] = true
+	}
+	var firstErr error
+	engine.filterJobs(func(job *copyJob) bool {
+		if firstErr != nil || job.tree || !pathExists(job.dst) {
+			return true
+		}
+		rel, err := filepath.Rel(plan.DestRoot, job.dst)
+		if err != nil {
+			firstErr = err
+			return true
+		}
+		c := fileConflict{Entry: job.entry, Path: filepath.ToSlash(rel)}
+		_, c.CanMerge = fileMergers[job.entry]
+		policy := policies[job.entry]
+		if policy == "" {
+			policy = defaultConflictPolicy(job.entry)
+		}
+		if policy == conflictAsk {
+			if p, ok := answered[job.entry]; ok {
+				policy = p
+			} else if ask == nil {
+				firstErr = fmt.Errorf("%s: the new pack has this file too and its conflict policy is ask; pick a policy to run without the interactive interface", c.Path)
+				return true
+			} else {
+				a, err := ask(ctx, c)
+				if err != nil {
+					firstErr = err
+					return true
+				}
+				policy = a.Policy
+				if a.All {
+					answered[job.entry] = policy
+				}
+			}
+		}
+		out := conflictOutcome{Path: c.Path, Policy: policy}
+		keep := true
+		switch policy {
+		case conflictKeepNew:
+			keep = false
+		case conflictKeepBoth:
+			job.dst = keepBothName(job.dst, taken)
+			taken[job.dst] = true
+			if rel, err := filepath.Rel(plan.DestRoot, job.dst); err == nil {
+				out.KeptAs = filepath.ToSlash(rel)
+			}
+		case conflictMerge:
+			merge, ok := fileMergers[job.entry]
+			if !ok {
+				firstErr = fmt.Errorf("%s cannot be merged", c.Path)
+				return true
+			}
+			res, err := merge(job.src, job.dst)
+			if err != nil {
+				firstErr = fmt.Errorf("merge file %s: %w", c.Path, err)
+				return true
+			}
+			res.Path = c.Path
+			report.FileMerges = append(report.FileMerges, res)
+			keep = false
+		}
+		report.Conflicts = append(report.Conflicts, out)
+		return keep
+	})
+	return firstErr
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveConflicts(t *testing.T) {
+	tests := []struct {
+		policy conflictPolicy
+		// want maps files of the new serverutilities folder to their contents
+		want map[string]string
+	}{
+		{conflictOverwrite, map[string]string{"a.cfg": "yours", "a.old.cfg": "your old", "b.cfg": "yours b"}},
+		{conflictKeepNew, map[string]string{"a.cfg": "pack", "a.old.cfg": "your old", "b.cfg": "yours b"}},
+		// a.old.cfg is queued but not written yet, so the user's a.cfg goes further
+		{conflictKeepBoth, map[string]string{"a.cfg": "pack", "a.old.cfg": "your old", "a.old2.cfg": "yours", "b.cfg": "yours b"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			src, dst := filepath.Join(dir, "old", "serverutilities"), filepath.Join(dir, "new", "serverutilities")
+			writeFile(t, filepath.Join(src, "a.cfg"), []byte("yours"))
+			writeFile(t, filepath.Join(src, "a.old.cfg"), []byte("your old"))
+			writeFile(t, filepath.Join(src, "b.cfg"), []byte("yours b"))
+			writeFile(t, filepath.Join(dst, "a.cfg"), []byte("pack"))
+
+			plan := &migrationPlan{DestRoot: filepath.Join(dir, "new"), Entries: []planEntry{{Path: "serverutilities", Conflict: tt.policy}}}
+			engine := newCopyEngine(nil)
+			if err := engine.addTree("serverutilities", src, dst, modeCopy, &transferStats{}, nil); err != nil {
+				t.Fatal(err)
+			}
+			report := &migrationReport{}
+			if err := resolveConflicts(context.Background(), plan, engine, nil, report); err != nil {
+				t.Fatal(err)
+			}
+			if err := engine.run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := os.ReadDir(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.want) {
+				t.Errorf("new folder has %d files, want %d", len(entries), len(tt.want))
+			}
+			for name, content := range tt.want {
+				data, err := os.ReadFile(filepath.Join(dst, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != content {
+					t.Errorf("%s holds %q, want %q", name, data, content)
+				}
+			}
+			if len(report.Conflicts) != 1 || report.Conflicts[0].Policy != tt.policy {
+				t.Errorf("conflicts %+v, want one settled with %s", report.Conflicts, tt.policy)
+			}
+		})
+	}
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	copyWorkers          = 8
+	copyProgressInterval = 100 * time.Millisecond
+)
+
+// copyJob is one unit of work for the copy engine: a file, or a whole folder that
+// is moved with a single rename.
+type copyJob struct {
+	entry string
+	src   string
+	dst   string
+	size  int64
+	files int
+	mode  transferMode
+	tree  bool
+	// link is the target of a symbolic link, recreated at dst instead of copying
+	// what it points to.
+	link  string
+	stats *transferStats
+}
+
+// dirJob is a folder the engine creates; it gets its source's permissions and
+// modification time once everything inside has been written.
+type dirJob struct {
+	dst     string
+	info    fs.FileInfo
+	existed bool
+}
+
+type movedPath struct {
+	from string
+	to   string
+}
+
+// copyEngine copies file trees with a bounded pool of workers. Everything is
+// walked up front so progress can be reported against exact byte totals. The
+// first error cancels the remaining work and is the one returned.
+type copyEngine struct {
+	workers  int
+	progress func(done, total int64)
+	// dereference copies what symbolic links point to instead of recreating them.
+	dereference bool
+	// exclude leaves out what its rules match inside the trees.
+	exclude *exclusions
+
+	jobs       []copyJob
+	dirs       []dirJob
+	moveRoots  []string
+	totalBytes int64
+	totalFiles int
+
+	done       atomic.Int64
+	lastReport atomic.Int64
+
+	mu    sync.Mutex
+	moved []movedPath
+}
+
+func newCopyEngine(progress func(done, total int64)) *copyEngine {
+	return &copyEngine{workers: copyWorkers, progress: progress}
+}
+
+// copyTree copies srcDir into dstDir and waits for it to finish.
+func (e *copyEngine) copyTree(ctx context.Context, srcDir, dstDir string) error {
+	if err := e.addTree("", srcDir, dstDir, modeCopy, &transferStats{}, nil); err != nil {
+		return err
+	}
+	return e.run(ctx)
+}
+
+// addTree queues every file under srcDir for the plan entry named entry, except
+// the files and folders in skip (slash-separated, relative to srcDir) and those
+// the exclusion rules leave out, which stats counts. A move into a folder the
+// new pack does not have becomes a single rename. A srcDir that is itself a
+// symbolic link is recreated as one where the new pack has nothing in its place.
+func (e *copyEngine) addTree(entry, srcDir, dstDir string, mode transferMode, stats *transferStats, skip []string) error {
+	if target, err := os.Readlink(srcDir); err == nil && !e.dereference {
+		if len(skip) == 0 && emptyOrMissing(dstDir) {
+			e.jobs = append(e.jobs, copyJob{entry: entry, src: srcDir, dst: dstDir, files: 1, mode: mode, tree: true, link: target, stats: stats})
+			e.totalFiles++
+			return nil
+		}
+		stats.Notes = append(stats.Notes, fmt.Sprintf("%s is a link to %s; the new pack has this folder, so what it points to was copied into it", filepath.Base(srcDir), target))
+	}
+	if mode == modeMove && !pathExists(dstDir) && len(skip) == 0 && !e.dereference && !e.excludesIn(entry, srcDir) {
+		files, bytes, err := dirStats(srcDir)
+		if err != nil {
+			return err
+		}
+		e.jobs = append(e.jobs, copyJob{entry: entry, src: srcDir, dst: dstDir, size: bytes, files: files, mode: mode, tree: true, stats: stats})
+		e.totalBytes += bytes
+		e.totalFiles += files
+		return nil
+	}
+	if mode == modeMove {
+		e.moveRoots = append(e.moveRoots, srcDir)
+	}
+	skipped := make(map[string]bool, len(skip))
+	for _, s := range skip {
+		skipped[s] = true
+	}
+	return walkTree(srcDir, e.dereference, func(p string, info fs.FileInfo) error {
+		rel, err := filepath.Rel(srcDir, p)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dstDir, rel)
+		if skipped[filepath.ToSlash(rel)] {
+			if info.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if e.exclude.excluded(gamePath(entry, rel), info) {
+			files, bytes, err := excludedStats(p, info, e.dereference)
+			if err != nil {
+				return err
+			}
+			stats.Filtered += files
+			stats.FilteredBytes += bytes
+			if info.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		switch {
+		case info.IsDir():
+			e.dirs = append(e.dirs, dirJob{dst: dst, info: info, existed: pathExists(dst)})
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			e.addLink(entry, p, dst, target, mode, stats)
+		default:
+			e.addFile(entry, p, dst, info.Size(), mode, stats)
+		}
+		return nil
+	}, func(p string) {
+		rel, _ := filepath.Rel(srcDir, p)
+		stats.Notes = append(stats.Notes, fmt.Sprintf("%s links back to a folder above it and was left out", filepath.ToSlash(rel)))
+	})
+}
+
+// excludesIn reports whether the exclusion rules leave out anything under srcDir,
+// which then cannot be moved with a single rename.
+func (e *copyEngine) excludesIn(entry, srcDir string) bool {
+	files, _, err := e.exclude.excludedIn(entry, srcDir, e.dereference, nil)
+	return err != nil || files > 0
+}
+
+// addLink queues the symbolic link src, recreated at dst.
+func (e *copyEngine) addLink(entry, src, dst, target string, mode transferMode, stats *transferStats) {
+	e.jobs = append(e.jobs, copyJob{entry: entry, src: src, dst: dst, files: 1, mode: mode, link: target, stats: stats})
+	e.totalFiles++
+}
+
+func (e *copyEngine) addFile(entry, src, dst string, size int64, mode transferMode, stats *transferStats) {
+	e.jobs = append(e.jobs, copyJob{entry: entry, src: src, dst: dst, size: size, files: 1, mode: mode, stats: stats})
+	e.totalBytes += size
+	e.totalFiles++
+}
+
+// filterJobs keeps the queued jobs keep returns true for. keep may change a
+// job's destination.
+func (e *copyEngine) filterJobs(keep func(job *copyJob) bool) {
+	jobs := e.jobs[:0]
+	e.totalBytes, e.totalFiles = 0, 0
+	for i := range e.jobs {
+		job := e.jobs[i]
+		if !keep(&job) {
+			continue
+		}
+		jobs = append(jobs, job)
+		e.totalBytes += job.size
+		e.totalFiles += job.files
+	}
+	e.jobs = jobs
+}
+
+// run executes the queued jobs. Cancelling ctx stops workers between chunks and
+// returns ctx's error.
+func (e *copyEngine) run(ctx context.Context) error {
+	for _, d := range e.dirs {
+		if err := os.MkdirAll(d.dst, 0o755); err != nil {
+			return err
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		once     sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	queue := make(chan *copyJob)
+	workers := e.workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if runCtx.Err() != nil {
+					continue
+				}
+				if err := e.runJob(runCtx, job); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+feed:
+	for i := range e.jobs {
+		select {
+		case queue <- &e.jobs[i]:
+		case <-runCtx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if e.progress != nil {
+		e.progress(e.done.Load(), e.totalBytes)
+	}
+	e.removeEmptiedSources()
+	// children were queued after their parents; stamping them first keeps the
+	// parents' times from being bumped again
+	for i := len(e.dirs) - 1; i >= 0; i-- {
+		if d := e.dirs[i]; !d.existed {
+			keepMetadata(d.dst, d.info)
+		}
+	}
+	return nil
+}
+
+func (e *copyEngine) runJob(ctx context.Context, job *copyJob) error {
+	var written int64
+	onBytes := func(n int64) {
+		written += n
+		e.advance(n)
+	}
+
+	var fallback, err error
+	if job.link != "" {
+		err = relink(job.src, job.dst, job.link, job.mode)
+	} else if job.tree {
+		fallback, err = moveTree(ctx, job.src, job.dst, onBytes)
+	} else {
+		fallback, err = transferFile(ctx, job.src, job.dst, job.mode, onBytes)
+	}
+	if err != nil {
+		return err
+	}
+	if rest := job.size - written; rest > 0 {
+		e.advance(rest)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	job.stats.Files += job.files
+	job.stats.Bytes += job.size
+	if fallback != nil {
+		job.stats.fellBack(fallback)
+	}
+	if job.mode == modeMove {
+		e.moved = append(e.moved, movedPath{from: job.src, to: job.dst})
+	}
+	return nil
+}
+
+func (e *copyEngine) advance(n int64) {
+	done := e.done.Add(n)
+	if e.progress == nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := e.lastReport.Load()
+	if now-last < int64(copyProgressInterval) || !e.lastReport.CompareAndSwap(last, now) {
+		return
+	}
+	e.progress(done, e.totalBytes)
+}
+
+// moveTree renames a whole folder, or copies it and deletes the original when the
+// rename crosses devices.
+func moveTree(ctx context.Context, src, dst string, onBytes func(int64)) (fallback, err error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return nil, err
+	}
+	if fallback = os.Rename(src, dst); fallback == nil {
+		return nil, nil
+	}
+	err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(filepath.Join(dst, rel), 0o755)
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return relink(p, filepath.Join(dst, rel), target, modeCopy)
+		}
+		return copyFileContext(ctx, p, filepath.Join(dst, rel), onBytes)
+	})
+	if err != nil {
+		return fallback, err
+	}
+	return fallback, os.RemoveAll(src)
+}
+
+// relink recreates the symbolic link src at dst, pointing at target as it did,
+// and removes src for a move.
+func relink(src, dst, target string, mode transferMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(target, dst); err != nil {
+		return fmt.Errorf("recreate link %s: %w (dereferencing links copies what they point to instead)", dst, err)
+	}
+	if mode == modeMove {
+		return os.Remove(src)
+	}
+	return nil
+}
+
+// emptyOrMissing reports whether dir does not exist or has nothing in it.
+func emptyOrMissing(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	return os.IsNotExist(err) || err == nil && len(entries) == 0
+}
+
+// walkTree calls fn for root and everything under it, folders before what they
+// hold, the way filepath.WalkDir does; fn may return fs.SkipDir to leave out a
+// folder. root is followed if it is a symbolic link. Links under it are passed to
+// fn as links unless follow is set, in which case fn gets what they point to;
+// loop is called instead for a followed link that leads back to a folder above
+// it. Dangling links are always passed as links.
+func walkTree(root string, follow bool, fn func(path string, info fs.FileInfo) error, loop func(path string)) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if err := walkTreeFrom(root, info, nil, follow, fn, loop); err != nil && err != fs.SkipDir {
+		return err
+	}
+	return nil
+}
+
+func walkTreeFrom(path string, info fs.FileInfo, parents []fs.FileInfo, follow bool, fn func(string, fs.FileInfo) error, loop func(string)) error {
+	if err := fn(path, info); err != nil || !info.IsDir() {
+		return err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	parents = append(parents, info)
+	for _, d := range entries {
+		child := filepath.Join(path, d.Name())
+		ci, err := os.Lstat(child)
+		if err != nil {
+			return err
+		}
+		if follow && ci.Mode()&fs.ModeSymlink != 0 {
+			if target, err := os.Stat(child); err == nil {
+				if target.IsDir() && slices.ContainsFunc(parents, func(p fs.FileInfo) bool { return os.SameFile(p, target) }) {
+					if loop != nil {
+						loop(child)
+					}
+					continue
+				}
+				ci = target
+			}
+		}
+		if err := walkTreeFrom(child, ci, parents, follow, fn, loop); err != nil && err != fs.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeEmptiedSources deletes the folders left empty in the source once a move
+// has taken every file out of them.
+func (e *copyEngine) removeEmptiedSources() {
+	for _, root := range e.moveRoots {
+		var dirs []string
+		_ = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				dirs = append(dirs, p)
+			}
+			return nil
+		})
+		sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+		for _, d := range dirs {
+			_ = os.Remove(d)
+		}
+	}
+}
+
+// undoMoves puts everything that was moved back into the source, so a failed
+// migration can delete the new instance without losing the user's data.
+func (e *copyEngine) undoMoves() error {
+	e.mu.Lock()
+	moved := e.moved
+	e.moved = nil
+	e.mu.Unlock()
+	var firstErr error
+	for i := len(moved) - 1; i >= 0; i-- {
+		m := moved[i]
+		if _, err := moveTree(context.Background(), m.to, m.from, nil); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// spaceItem is one thing a migration writes and how much room it takes.
+type spaceItem struct {
+	What  string `json:"what"`
+	Bytes int64  `json:"bytes"`
+}
+
+// spaceNeed is what a migration writes to one filesystem. Free is -1 when the
+// filesystem cannot tell.
+type spaceNeed struct {
+	// Paths are where the items go, for the breakdown.
+	Paths []string    `json:"paths"`
+	Items []spaceItem `json:"items"`
+	Free  int64       `json:"free"`
+	fsID  string
+}
+
+func (n *spaceNeed) total() int64 {
+	var sum int64
+	for _, it := range n.Items {
+		sum += it.Bytes
+	}
+	return sum
+}
+
+func (n *spaceNeed) short() bool {
+	return n.Free >= 0 && n.total() > n.Free
+}
+
+func (n *spaceNeed) describe() string {
+	free := "free space unknown"
+	if n.Free >= 0 {
+		free = formatBytes(n.Free) + " free"
+	}
+	lines := []string{fmt.Sprintf("%s: needs %s, %s", strings.Join(n.Paths, " and "), formatBytes(n.total()), free)}
+	for _, it := range n.Items {
+		lines = append(lines, fmt.Sprintf("  %-44s %10s", it.What, formatBytes(it.Bytes)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// zipUncompressedSize is how much an archive takes once extracted.
+func zipUncompressedSize(files []*zip.File) int64 {
+	var sum int64
+	for _, f := range files {
+		sum += int64(f.UncompressedSize64)
+	}
+	return sum
+}
+
+// existingAncestor is path, or the nearest folder above it that exists.
+func existingAncestor(path string) string {
+	for {
+		if pathExists(path) {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// spaceNeeded works out how much plan writes to the temp folder, the backup
+// folder and the destination, grouped by filesystem so two folders on one disk share its free
+// space. Hardlinked and moved entries only take room when the source is on
+// another filesystem; reflinks are counted in full, as they fall back to copying.
+func (p *migrationPlan) spaceNeeded() ([]*spaceNeed, error) {
+	var needs []*spaceNeed
+	add := func(path, what string, bytes int64) error {
+		if bytes <= 0 {
+			return nil
+		}
+		probe := existingAncestor(path)
+		space, err := diskSpace(probe)
+		if err != nil {
+			return fmt.Errorf("free space of %s: %w", probe, err)
+		}
+		for _, n := range needs {
+			if n.fsID == space.ID {
+				if !slices.Contains(n.Paths, path) {
+					n.Paths = append(n.Paths, path)
+				}
+				n.Items = append(n.Items, spaceItem{What: what, Bytes: bytes})
+				return nil
+			}
+		}
+		needs = append(needs, &spaceNeed{Paths: []string{path}, Items: []spaceItem{{What: what, Bytes: bytes}}, Free: space.Free, fsID: space.ID})
+		return nil
+	}
+
+	if err := add(os.TempDir(), "download of "+p.Release.FileName, p.Release.Size); err != nil {
+		return nil, err
+	}
+	if err := add(p.Destination, "extracted release", p.Release.Uncompressed); err != nil {
+		return nil, err
+	}
+	if b := p.Backup; b != nil && b.Enabled {
+		bytes, err := b.estimatedBytes()
+		if err != nil {
+			return nil, fmt.Errorf("estimate backup size: %w", err)
+		}
+		if err := add(b.Dir, b.Format+" backup of "+strings.Join(b.Entries, ", "), bytes); err != nil {
+			return nil, err
+		}
+	}
+	var destID string
+	if space, err := diskSpace(existingAncestor(p.Destination)); err == nil {
+		destID = space.ID
+	}
+	for _, e := range p.Entries {
+		if e.Excluded || e.Link != "" && !p.Dereference {
+			continue
+		}
+		if e.Mode == modeHardlink || e.Mode == modeMove {
+			if space, err := diskSpace(e.Source); err == nil && space.ID == destID {
+				continue
+			}
+		}
+		if err := add(p.Destination, fmt.Sprintf("%s %s", e.Mode, filepath.ToSlash(e.Path)), e.Bytes); err != nil {
+			return nil, err
+		}
+	}
+	return needs, nil
+}
+
+// diskSpaceError stops a migration that would run out of room part way.
+type diskSpaceError struct {
+	Needs []*spaceNeed
+}
+
+func (e *diskSpaceError) Error() string {
+	lines := []string{"not enough free disk space"}
+	for _, n := range e.Needs {
+		if n.short() {
+			lines = append(lines, n.describe())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkDiskSpace fails with a *diskSpaceError when a filesystem plan writes to
+// lacks the room.
+func checkDiskSpace(plan *migrationPlan) error {
+	needs, err := plan.spaceNeeded()
+	if err != nil {
+		return err
+	}
+	for _, n := range needs {
+		if n.short() {
+			return &diskSpaceError{Needs: needs}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsSpace is the free space of a filesystem and an ID telling filesystems apart.
+type fsSpace struct {
+	Free int64
+	ID   string
+}
+
+func diskSpace(path string) (fsSpace, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return fsSpace{}, err
+	}
+	var info unix.Stat_t
+	if err := unix.Stat(path, &info); err != nil {
+		return fsSpace{}, err
+	}
+	return fsSpace{Free: int64(st.Bavail) * int64(st.Bsize), ID: fmt.Sprint(info.Dev)}, nil
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// fsSpace is the free space of a filesystem and an ID telling filesystems apart.
+type fsSpace struct {
+	Free int64
+	ID   string
+}
+
+func diskSpace(path string) (fsSpace, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fsSpace{}, err
+	}
+	p, err := windows.UTF16PtrFromString(abs)
+	if err != nil {
+		return fsSpace{}, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return fsSpace{}, err
+	}
+	return fsSpace{Free: int64(free), ID: strings.ToUpper(filepath.VolumeName(abs))}, nil
+}
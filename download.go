@@ -2,6 +2,7 @@ package main
 
 import (
 	"archive/zip"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -41,15 +42,12 @@ func (p *progressReader) read() int64 {
 	return atomic.LoadInt64(&p.readBytes)
 }
 
-// downloadVersionZip downloads the selected version zip into destDir and returns the file path.
-// If progress is non-nil it will receive the number of bytes downloaded and the total size (when known).
-func downloadVersionZip(versionRef, destDir string, progress func(downloaded, total int64)) (string, error) {
+// resolveVersionURL turns a version reference (a listing entry or a full URL) into
+// the download URL and the file name the archive is saved under.
+func resolveVersionURL(versionRef string) (string, string, error) {
 	versionRef = strings.TrimSpace(versionRef)
 	if versionRef == "" {
-		return "", fmt.Errorf("empty version file name")
-	}
-	if err := os.MkdirAll(destDir, 0o755); err != nil {
-		return "", err
+		return "", "", fmt.Errorf("empty version file name")
 	}
 
 	var (
@@ -70,10 +68,32 @@ func downloadVersionZip(versionRef, destDir string, progress func(downloaded, to
 	}
 
 	if fileName == "" || fileName == "." || fileName == "/" {
-		return "", fmt.Errorf("cannot determine filename from %q", versionRef)
+		return "", "", fmt.Errorf("cannot determine filename from %q", versionRef)
+	}
+	return downloadURL, fileName, nil
+}
+
+// downloadVersionZip downloads the selected version zip into destDir and returns the file path.
+// If progress is non-nil it will receive the number of bytes downloaded and the total size (when known).
+func downloadVersionZip(ctx context.Context, versionRef, destDir string, progress func(downloaded, total int64)) (string, error) {
+	versionRef = strings.TrimSpace(versionRef)
+	if versionRef == "" {
+		return "", fmt.Errorf("empty version file name")
+	}
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return "", err
+	}
+
+	downloadURL, fileName, err := resolveVersionURL(versionRef)
+	if err != nil {
+		return "", err
 	}
 
-	resp, err := http.Get(downloadURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exclusionRule leaves files inside the migrated folders behind.
+type exclusionRule struct {
+	// Pattern is a glob over slash-separated paths relative to the game folder,
+	// e.g. "journeymap/data/mp/*"; one without a slash matches a name at any
+	// depth, e.g. ".DS_Store". A rule matching a folder covers what is in it.
+	Pattern string `json:"pattern"`
+	// OlderThan limits the rule to files last changed longer ago than this, e.g.
+	// "30d", "2w" or "12h".
+	OlderThan string `json:"olderThan,omitempty"`
+}
+
+func (r exclusionRule) describe() string {
+	if r.OlderThan == "" {
+		return r.Pattern
+	}
+	return fmt.Sprintf("%s older than %s", r.Pattern, r.OlderThan)
+}
+
+// junkExclusions are the files operating systems leave in folders, never worth
+// carrying over.
+var junkExclusions = []exclusionRule{
+	{Pattern: ".DS_Store"},
+	{Pattern: "._*"},
+	{Pattern: "Thumbs.db"},
+	{Pattern: "desktop.ini"},
+}
+
+// parseExclusionRule reads "pattern" or "pattern=age", as the -exclude flag takes them.
+func parseExclusionRule(s string) (exclusionRule, error) {
+	pattern, age, _ := strings.Cut(strings.TrimSpace(s), "=")
+	r := exclusionRule{Pattern: strings.TrimSpace(pattern), OlderThan: strings.TrimSpace(age)}
+	if _, err := compileExclusions([]exclusionRule{r}, time.Now()); err != nil {
+		return exclusionRule{}, err
+	}
+	return r, nil
+}
+
+// parseAge reads an age in days ("30d"), weeks ("2w") or anything
+// time.ParseDuration takes ("12h").
+func parseAge(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	if u, ok := units[s[max(len(s)-1, 0):]]; ok {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * u, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q (want e.g. 30d, 2w or 12h)", s)
+	}
+	return d, nil
+}
+
+type compiledExclusion struct {
+	pattern  string
+	anywhere bool
+	age      time.Duration
+}
+
+// matches reports whether the rule's pattern matches rel or a folder above it.
+func (r compiledExclusion) matches(rel string) bool {
+	for p := rel; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		name := p
+		if r.anywhere {
+			name = path.Base(p)
+		}
+		if ok, _ := path.Match(r.pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// exclusions are rules ready to match, with ages measured from now.
+type exclusions struct {
+	rules []compiledExclusion
+	now   time.Time
+}
+
+func compileExclusions(rules []exclusionRule, now time.Time) (*exclusions, error) {
+	x := &exclusions{now: now}
+	for _, r := range rules {
+		pattern := strings.Trim(filepath.ToSlash(r.Pattern), "/")
+		if pattern == "" {
+			return nil, fmt.Errorf("exclusion rule without a pattern")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("exclusion pattern %q: %w", r.Pattern, err)
+		}
+		c := compiledExclusion{pattern: pattern, anywhere: !strings.Contains(pattern, "/")}
+		if r.OlderThan != "" {
+			age, err := parseAge(r.OlderThan)
+			if err != nil {
+				return nil, fmt.Errorf("exclusion pattern %q: %w", r.Pattern, err)
+			}
+			c.age = age
+		}
+		x.rules = append(x.rules, c)
+	}
+	return x, nil
+}
+
+// excluded reports whether the rules leave out rel, slash-separated and relative
+// to the game folder. A folder is left out whole only by a rule without an age;
+// rules with one are tried on each file inside.
+func (x *exclusions) excluded(rel string, info fs.FileInfo) bool {
+	if x == nil {
+		return false
+	}
+	for _, r := range x.rules {
+		if !r.matches(rel) {
+			continue
+		}
+		if r.age == 0 || !info.IsDir() && x.now.Sub(info.ModTime()) > r.age {
+			return true
+		}
+	}
+	return false
+}
+
+// gamePath is rel, relative to the migrated folder entry, made relative to the
+// game folder.
+func gamePath(entry, rel string) string {
+	return path.Join(filepath.ToSlash(entry), filepath.ToSlash(rel))
+}
+
+// excludedStats counts what leaving out p takes away: every file under a folder,
+// or the file itself.
+func excludedStats(p string, info fs.FileInfo, follow bool) (files int, bytes int64, err error) {
+	if info.IsDir() {
+		return treeStats(p, follow)
+	}
+	if info.Mode().IsRegular() {
+		bytes = info.Size()
+	}
+	return 1, bytes, nil
+}
+
+// excludedIn counts the files under dir, the migrated folder entry, that the
+// rules leave out, not counting the paths in skip.
+func (x *exclusions) excludedIn(entry, dir string, follow bool, skip []string) (files int, bytes int64, err error) {
+	if x == nil || len(x.rules) == 0 {
+		return 0, 0, nil
+	}
+	skipped := make(map[string]bool, len(skip))
+	for _, s := range skip {
+		skipped[s] = true
+	}
+	err = walkTree(dir, follow, func(p string, info fs.FileInfo) error {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if skipped[filepath.ToSlash(rel)] {
+			if info.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !x.excluded(gamePath(entry, rel), info) {
+			return nil
+		}
+		f, b, err := excludedStats(p, info, follow)
+		if err != nil {
+			return err
+		}
+		files, bytes = files+f, bytes+b
+		if info.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	}, nil)
+	return files, bytes, err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+)
+
+// forgeConfig is a parsed Forge configuration file. The original lines are kept so
+// a file can be written back with only the changed properties rewritten, leaving
+// comments and layout alone.
+type forgeConfig struct {
+	newline string
+	lines   []string
+	props   []forgeProp
+	index   map[string]int
+}
+
+// forgeProp is one property such as `B:enabled=true` or a `S:list <` ... `>` block.
+// key is the category path plus the property head, e.g. "general/client/B:enabled".
+type forgeProp struct {
+	key    string
+	head   string
+	value  string
+	list   bool
+	indent string
+	start  int
+	end    int
+}
+
+func readForgeConfig(path string) (*forgeConfig, error) {
+	lines, newline, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := parseForgeConfig(lines)
+	cfg.newline = newline
+	return cfg, nil
+}
+
+func parseForgeConfig(lines []string) *forgeConfig {
+	cfg := &forgeConfig{newline: "\n", lines: lines, index: make(map[string]int)}
+	var (
+		categories []string
+		open       *forgeProp
+		items      []string
+	)
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if open != nil {
+			if trimmed == ">" {
+				open.value = strings.Join(items, "\n")
+				open.end = i
+				cfg.add(*open)
+				open, items = nil, nil
+			} else {
+				items = append(items, trimmed)
+			}
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "~") {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		switch {
+		case trimmed == "}":
+			if len(categories) > 0 {
+				categories = categories[:len(categories)-1]
+			}
+		case strings.Contains(trimmed, "="):
+			head, value, _ := strings.Cut(trimmed, "=")
+			head = strings.TrimSpace(head)
+			cfg.add(forgeProp{key: forgeKey(categories, head), head: head, value: value, indent: indent, start: i, end: i})
+		case strings.HasSuffix(trimmed, "<"):
+			head := strings.TrimSpace(strings.TrimSuffix(trimmed, "<"))
+			open = &forgeProp{key: forgeKey(categories, head), head: head, list: true, indent: indent, start: i}
+		case strings.HasSuffix(trimmed, "{"):
+			name := strings.TrimSpace(strings.TrimSuffix(trimmed, "{"))
+			categories = append(categories, strings.Trim(name, "\""))
+		}
+	}
+	return cfg
+}
+
+func forgeKey(categories []string, head string) string {
+	return strings.Join(append(append([]string{}, categories...), head), "/")
+}
+
+func (c *forgeConfig) add(p forgeProp) {
+	if _, dup := c.index[p.key]; dup {
+		return
+	}
+	c.index[p.key] = len(c.props)
+	c.props = append(c.props, p)
+}
+
+func (c *forgeConfig) get(key string) (string, bool) {
+	idx, ok := c.index[key]
+	if !ok {
+		return "", false
+	}
+	return c.props[idx].value, true
+}
+
+// render returns the file's lines with the given property values substituted.
+func (c *forgeConfig) render(values map[string]string) []string {
+	out := make([]string, 0, len(c.lines))
+	next := 0
+	for _, p := range c.props {
+		value, ok := values[p.key]
+		if !ok || value == p.value {
+			continue
+		}
+		out = append(out, c.lines[next:p.start]...)
+		if p.list {
+			out = append(out, p.indent+p.head+" <")
+			if value != "" {
+				for _, item := range strings.Split(value, "\n") {
+					out = append(out, p.indent+"    "+item)
+				}
+			}
+			out = append(out, p.indent+" >")
+		} else {
+			out = append(out, p.indent+p.head+"="+value)
+		}
+		next = p.end + 1
+	}
+	return append(out, c.lines[next:]...)
+}
+
+// write saves lines to path using the file's original line endings.
+func (c *forgeConfig) write(path string, lines []string) error {
+	return writeLines(path, lines, c.newline)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// activeWorldWindow is how recently a world's files must have been written for it
+// to count as open. Minecraft 1.7.10 holds no OS lock on session.lock; it writes
+// the file when it opens the world and saves level.dat every 45 seconds while it
+// runs.
+const activeWorldWindow = 2 * time.Minute
+
+// launcherRunWindow is how long a launch the launcher never recorded the end of
+// counts as running; older ones are taken for a launcher that crashed.
+const launcherRunWindow = 24 * time.Hour
+
+// gameRunningError refuses to touch an instance the game has open: copying a
+// region file while the game writes it can tear it.
+type gameRunningError struct {
+	Instance string
+	// Worlds are the open worlds, each with what gave it away.
+	Worlds []string
+	// PIDs are Java processes running the instance.
+	PIDs []int
+	// Launcher is set when the launcher has the instance marked as running.
+	Launcher string
+}
+
+func (e *gameRunningError) Error() string {
+	var why []string
+	if len(e.Worlds) > 0 {
+		why = append(why, "worlds open: "+strings.Join(e.Worlds, ", "))
+	}
+	if len(e.PIDs) > 0 {
+		why = append(why, fmt.Sprintf("game process %v", e.PIDs))
+	}
+	if e.Launcher != "" {
+		why = append(why, e.Launcher)
+	}
+	return fmt.Sprintf("the game is running from %s (%s); close it and try again", e.Instance, strings.Join(why, "; "))
+}
+
+// checkGameStopped returns a *gameRunningError when a world of instance is open,
+// a game process runs the instance or the launcher has it marked as running. It
+// fails when running processes cannot be listed rather than assume the game is
+// closed.
+func checkGameStopped(instance string) error {
+	now := time.Now()
+	running := &gameRunningError{Instance: instance}
+	root := destinationRoot(instance, pathExists)
+	entries, err := os.ReadDir(filepath.Join(root, "saves"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		why, err := worldOpen(filepath.Join(root, "saves", e.Name()), now)
+		if err != nil {
+			return fmt.Errorf("check the session lock of %s: %w", e.Name(), err)
+		}
+		if why != "" {
+			running.Worlds = append(running.Worlds, fmt.Sprintf("%s (%s)", e.Name(), why))
+		}
+	}
+	running.Launcher = launcherRunning(instance, now)
+	if running.PIDs, err = gameProcesses(instance); err != nil {
+		return fmt.Errorf("cannot tell whether the game is running from %s, so nothing was changed; close it and try again: %w", instance, err)
+	}
+	if len(running.Worlds) > 0 || len(running.PIDs) > 0 || running.Launcher != "" {
+		return running
+	}
+	return nil
+}
+
+// worldOpen says what shows the world in dir is open, or "" when nothing does:
+// an OS lock on its session.lock, as newer game versions take, or a session.lock
+// or level.dat written within activeWorldWindow.
+func worldOpen(dir string, now time.Time) (string, error) {
+	held, err := sessionLockHeld(filepath.Join(dir, "session.lock"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if held {
+		return "session.lock held", nil
+	}
+	for _, name := range []string{"session.lock", "level.dat"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		if age := now.Sub(info.ModTime()); age < activeWorldWindow {
+			return fmt.Sprintf("%s written %s ago", name, max(age, 0).Round(time.Second)), nil
+		}
+	}
+	return "", nil
+}
+
+// launcherRunning says why the launcher has instance marked as running, or ""
+// when it does not. MultiMC and Prism Launcher set lastLaunchTime in instance.cfg
+// when they start the game and write the play time to the file when it stops, so
+// a launch the file has not been written since is still going.
+func launcherRunning(instance string, now time.Time) string {
+	path := instanceConfigPath(instance)
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	cfg, err := readInstanceConfig(path)
+	if err != nil {
+		return ""
+	}
+	raw, ok := cfg.get("lastLaunchTime")
+	if !ok {
+		return ""
+	}
+	ms, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	if err != nil {
+		return ""
+	}
+	launched := time.UnixMilli(ms)
+	if info.ModTime().Sub(launched) > 10*time.Second || now.Sub(launched) > launcherRunWindow {
+		return ""
+	}
+	return fmt.Sprintf("the launcher started it at %s and has not recorded it stopping", launched.Format("15:04"))
+}
+
+// instanceDirs are the spellings of instance a process may use: as given, made
+// absolute, and with symbolic links resolved.
+func instanceDirs(instance string) []string {
+	dirs := []string{filepath.Clean(instance)}
+	if abs, err := filepath.Abs(instance); err == nil {
+		dirs = append(dirs, abs)
+		if real, err := filepath.EvalSymlinks(abs); err == nil {
+			dirs = append(dirs, real)
+		}
+	}
+	return dirs
+}
+
+// mentionsInstance reports whether a command line or working folder names a path
+// inside one of dirs. Launchers pass the instance's natives folder to Java and
+// start it in the instance's game folder.
+func mentionsInstance(s string, dirs []string) bool {
+	fold := runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+	s = filepath.ToSlash(s)
+	if fold {
+		s = strings.ToLower(s)
+	}
+	for _, dir := range dirs {
+		d := strings.TrimSuffix(filepath.ToSlash(dir), "/")
+		if fold {
+			d = strings.ToLower(d)
+		}
+		for rest := s; ; {
+			i := strings.Index(rest, d)
+			if i < 0 {
+				break
+			}
+			// "instances/GTNH" must not match "instances/GTNH2"
+			end := i + len(d)
+			if end == len(rest) || strings.ContainsRune("/\"' ;:", rune(rest[end])) {
+				return true
+			}
+			rest = rest[end:]
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMentionsInstance(t *testing.T) {
+	dirs := []string{"/home/p/instances/GTNH"}
+	tests := []struct {
+		args string
+		want bool
+	}{
+		{"java -Djava.library.path=/home/p/instances/GTNH/natives -cp x Main", true},
+		{"/home/p/instances/GTNH", true},
+		{"java -Djava.library.path=/home/p/instances/GTNH2/natives", false},
+		{"java -jar server.jar", false},
+	}
+	for _, tt := range tests {
+		if got := mentionsInstance(tt.args, dirs); got != tt.want {
+			t.Errorf("mentionsInstance(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestWorldOpen(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		age  time.Duration
+		open bool
+	}{
+		{"session.lock", 10 * time.Second, true},
+		{"level.dat", time.Minute, true},
+		{"level.dat", time.Hour, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if tt.name != "" {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, time.Time{}, now.Add(-tt.age)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		why, err := worldOpen(dir, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (why != "") != tt.open {
+			t.Errorf("%s written %s ago: open = %q, want %v", tt.name, tt.age, why, tt.open)
+		}
+	}
+}
+
+func TestLauncherRunning(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		desc     string
+		launched time.Time
+		written  time.Time
+		running  bool
+	}{
+		{"launched, not stopped", now.Add(-time.Hour), now.Add(-time.Hour), true},
+		{"stopped since", now.Add(-time.Hour), now.Add(-time.Minute), false},
+		{"stale launch", now.Add(-48 * time.Hour), now.Add(-48 * time.Hour), false},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		path := instanceConfigPath(dir)
+		cfg := fmt.Sprintf("[General]\nname=GTNH\nlastLaunchTime=%d\n", tt.launched.UnixMilli())
+		if err := os.WriteFile(path, []byte(cfg), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, time.Time{}, tt.written); err != nil {
+			t.Fatal(err)
+		}
+		if got := launcherRunning(dir, now) != ""; got != tt.running {
+			t.Errorf("%s: running = %v, want %v", tt.desc, got, tt.running)
+		}
+	}
+}
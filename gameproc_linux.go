@@ -0,0 +1,39 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// gameProcesses lists the Java processes running instance: started in its folder
+// or given a path inside it on the command line.
+func gameProcesses(instance string) ([]int, error) {
+	dirs := instanceDirs(instance)
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		// processes of other users or gone by now cannot be read; skip them
+		comm, err := os.ReadFile(filepath.Join("/proc", p.Name(), "comm"))
+		if err != nil || !strings.HasPrefix(strings.TrimSpace(string(comm)), "java") {
+			continue
+		}
+		cwd, _ := os.Readlink(filepath.Join("/proc", p.Name(), "cwd"))
+		cmdline, _ := os.ReadFile(filepath.Join("/proc", p.Name(), "cmdline"))
+		args := strings.ReplaceAll(string(cmdline), "\x00", " ")
+		if cwd != "" && mentionsInstance(cwd, dirs) || mentionsInstance(args, dirs) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package main
+
+import "errors"
+
+// gameProcesses cannot list processes here.
+func gameProcesses(instance string) ([]int, error) {
+	return nil, errors.New("listing processes is not supported on this platform")
+}
@@ -0,0 +1,43 @@
+//go:build unix && !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// gameProcesses lists the Java processes whose command line names a path inside
+// instance, as ps reports them.
+func gameProcesses(instance string) ([]int, error) {
+	out, err := exec.Command("ps", "-axww", "-o", "pid=", "-o", "args=").Output()
+	if err != nil {
+		return nil, fmt.Errorf("list processes: %w", err)
+	}
+	dirs := instanceDirs(instance)
+	var pids []int
+	for _, line := range strings.Split(string(out), "\n") {
+		id, args, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		pid, err := strconv.Atoi(id)
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		if isJavaCommand(args) && mentionsInstance(args, dirs) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// isJavaCommand reports whether a command line runs Java. The program's path may
+// hold spaces, so its name is looked for rather than split off.
+func isJavaCommand(args string) bool {
+	args = strings.ToLower(args)
+	return strings.HasPrefix(args, "java ") || strings.Contains(args, "/java ") || strings.Contains(args, "/java\"")
+}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// gameProcesses lists the java.exe and javaw.exe processes whose command line
+// names a path inside instance. Windows keeps other processes' command lines out
+// of reach of plain system calls, so PowerShell is asked for them.
+func gameProcesses(instance string) ([]int, error) {
+	script := `Get-CimInstance Win32_Process -Filter "Name like 'java%'" | ForEach-Object { "$($_.ProcessId)` + "`t" + `$($_.CommandLine)" }`
+	out, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).Output()
+	if err != nil {
+		return nil, fmt.Errorf("list processes: %w", err)
+	}
+	dirs := instanceDirs(instance)
+	var pids []int
+	for _, line := range strings.Split(string(out), "\n") {
+		id, args, ok := strings.Cut(strings.TrimSpace(line), "\t")
+		if !ok {
+			continue
+		}
+		pid, err := strconv.Atoi(id)
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		if mentionsInstance(args, dirs) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// instanceSettingKeys are the launcher settings in instance.cfg that belong to the
+// user rather than the pack and are carried over to the new instance.
+var instanceSettingKeys = []string{
+	"OverrideMemory",
+	"MaxMemAlloc",
+	"MinMemAlloc",
+	"PermGen",
+	"OverrideJavaArgs",
+	"JvmArgs",
+	"OverrideJavaLocation",
+	"JavaPath",
+	"JavaArchitecture",
+	"JavaRealArchitecture",
+	"JavaVendor",
+	"JavaVersion",
+	"JavaSignature",
+	"OverrideWindow",
+	"LaunchMaximized",
+	"MinecraftWinWidth",
+	"MinecraftWinHeight",
+	"OverrideCommands",
+	"WrapperCommand",
+	"PreLaunchCommand",
+	"PostExitCommand",
+	"notes",
+}
+
+// instanceJavaKeys are the settings among instanceSettingKeys that only work with
+// the Java the instance ran on: its path, and flags such as PermGen or
+// -XX:+UseConcMarkSweepGC that Java 17 refuses.
+var instanceJavaKeys = map[string]bool{
+	"PermGen":              true,
+	"OverrideJavaArgs":     true,
+	"JvmArgs":              true,
+	"OverrideJavaLocation": true,
+	"JavaPath":             true,
+	"JavaArchitecture":     true,
+	"JavaRealArchitecture": true,
+	"JavaVendor":           true,
+	"JavaVersion":          true,
+	"JavaSignature":        true,
+}
+
+var releaseJavaPattern = regexp.MustCompile(`(?i)Java_(\d+)(?:-(\d+))?`)
+
+// releaseJava reads the Java versions a release runs on from its file name, e.g.
+// GTNH_2.8.0_Java_17-21.zip.
+func releaseJava(fileName string) (lo, hi int, ok bool) {
+	m := releaseJavaPattern.FindStringSubmatch(fileName)
+	if m == nil {
+		return 0, 0, false
+	}
+	lo, _ = strconv.Atoi(m[1])
+	hi = lo
+	if m[2] != "" {
+		hi, _ = strconv.Atoi(m[2])
+	}
+	return lo, hi, true
+}
+
+// javaMajor is the major version of a Java version string: 8 for "1.8.0_372",
+// 17 for "17.0.8".
+func javaMajor(version string) int {
+	parts := strings.FieldsFunc(strings.TrimSpace(version), func(r rune) bool { return r < '0' || r > '9' })
+	if len(parts) == 0 {
+		return 0
+	}
+	n, _ := strconv.Atoi(parts[0])
+	if n == 1 && len(parts) > 1 {
+		n, _ = strconv.Atoi(parts[1])
+	}
+	return n
+}
+
+// instanceConfig is a launcher instance.cfg (a Qt settings file). Lines are kept
+// as they are so unrelated keys and their escaping survive a rewrite.
+type instanceConfig struct {
+	newline string
+	lines   []string
+}
+
+func instanceConfigPath(instancePath string) string {
+	return filepath.Join(instancePath, "instance.cfg")
+}
+
+func readInstanceConfig(path string) (*instanceConfig, error) {
+	lines, newline, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	return &instanceConfig{newline: newline, lines: lines}, nil
+}
+
+func (c *instanceConfig) find(key string) int {
+	for i, line := range c.lines {
+		k, _, ok := strings.Cut(line, "=")
+		if ok && strings.TrimSpace(k) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// get returns the raw (still escaped) value of key.
+func (c *instanceConfig) get(key string) (string, bool) {
+	idx := c.find(key)
+	if idx < 0 {
+		return "", false
+	}
+	_, v, _ := strings.Cut(c.lines[idx], "=")
+	return v, true
+}
+
+// set replaces key's raw value, adding the key to the [General] section (or the
+// end of the file when there are no sections) if it is missing.
+func (c *instanceConfig) set(key, value string) {
+	line := key + "=" + value
+	if idx := c.find(key); idx >= 0 {
+		c.lines[idx] = line
+		return
+	}
+	insert := len(c.lines)
+	inGeneral := false
+	for i, l := range c.lines {
+		trimmed := strings.TrimSpace(l)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			if inGeneral {
+				insert = i
+				break
+			}
+			inGeneral = trimmed == "[General]"
+		}
+	}
+	c.lines = append(c.lines[:insert], append([]string{line}, c.lines[insert:]...)...)
+}
+
+func (c *instanceConfig) write(path string) error {
+	return writeLines(path, c.lines, c.newline)
+}
+
+// instanceSettingsPlan is the launcher settings step of a migration plan.
+type instanceSettingsPlan struct {
+	SourceFile string   `json:"sourceFile"`
+	Keys       []string `json:"keys"`
+	Name       string   `json:"name"`
+	// LeftOut are the Java settings not carried over because the new release may
+	// run on another Java; JavaNote says why.
+	LeftOut  []string `json:"leftOut,omitempty"`
+	JavaNote string   `json:"javaNote,omitempty"`
+}
+
+type instanceSettingsResult struct {
+	Copied   []string `json:"copied"`
+	Name     string   `json:"name"`
+	LeftOut  []string `json:"leftOut,omitempty"`
+	JavaNote string   `json:"javaNote,omitempty"`
+}
+
+// planInstanceSettings picks the settings of the source's instance.cfg to carry
+// over into an instance of releaseFile. The Java settings come along only when the
+// Java the source ran on is one the release runs on too.
+func planInstanceSettings(source, dest, releaseFile string) (*instanceSettingsPlan, error) {
+	sp := &instanceSettingsPlan{SourceFile: instanceConfigPath(source), Name: filepath.Base(dest)}
+	cfg, err := readInstanceConfig(sp.SourceFile)
+	if os.IsNotExist(err) {
+		return sp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sp.JavaNote = javaMismatch(cfg, releaseFile)
+	for _, key := range instanceSettingKeys {
+		if _, ok := cfg.get(key); !ok {
+			continue
+		}
+		if instanceJavaKeys[key] && sp.JavaNote != "" {
+			sp.LeftOut = append(sp.LeftOut, key)
+		} else {
+			sp.Keys = append(sp.Keys, key)
+		}
+	}
+	if len(sp.LeftOut) == 0 {
+		sp.JavaNote = ""
+	}
+	return sp, nil
+}
+
+// javaMismatch says why the source's Java settings may not work for releaseFile,
+// or "" when the Java they name is one the release runs on.
+func javaMismatch(cfg *instanceConfig, releaseFile string) string {
+	lo, hi, ok := releaseJava(releaseFile)
+	if !ok {
+		return "the Java the new release needs is not known"
+	}
+	need := fmt.Sprintf("Java %d", lo)
+	if hi != lo {
+		need = fmt.Sprintf("Java %d-%d", lo, hi)
+	}
+	raw, _ := cfg.get("JavaVersion")
+	major := javaMajor(raw)
+	if major == 0 {
+		return "the instance does not record its Java version; the new release needs " + need
+	}
+	if major < lo || major > hi {
+		return fmt.Sprintf("the instance ran on Java %d; the new release needs %s", major, need)
+	}
+	return ""
+}
+
+// applyInstanceSettings copies the planned keys from the source instance.cfg into
+// the new one and names the new instance after its folder.
+func applyInstanceSettings(sp *instanceSettingsPlan, destInstance string) (*instanceSettingsResult, error) {
+	if sp == nil {
+		return nil, nil
+	}
+	destPath := instanceConfigPath(destInstance)
+	dst, err := readInstanceConfig(destPath)
+	if os.IsNotExist(err) {
+		dst, err = &instanceConfig{newline: "\n", lines: []string{"InstanceType=OneSix"}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := &instanceSettingsResult{Name: sp.Name, LeftOut: sp.LeftOut, JavaNote: sp.JavaNote}
+	if len(sp.Keys) > 0 {
+		src, err := readInstanceConfig(sp.SourceFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range sp.Keys {
+			if v, ok := src.get(key); ok {
+				dst.set(key, v)
+				res.Copied = append(res.Copied, key)
+			}
+		}
+	}
+	dst.set("name", qtSettingsString(sp.Name))
+	return res, dst.write(destPath)
+}
+
+// qtSettingsString quotes a value that Qt would otherwise read as a list or trim.
+func qtSettingsString(s string) string {
+	if strings.ContainsAny(s, ",;\"") || strings.TrimSpace(s) != s {
+		return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+	}
+	return s
+}
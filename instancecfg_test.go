@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPlanInstanceSettingsJava(t *testing.T) {
+	tests := []struct {
+		name    string
+		java    string
+		release string
+		keys    []string
+		leftOut []string
+	}{
+		{"same Java", "JavaVersion=17.0.8", "GTNH_2.8.0_Java_17-21.zip", []string{"MaxMemAlloc", "JvmArgs", "JavaPath", "JavaVersion"}, nil},
+		{"Java 8 to 17", "JavaVersion=1.8.0_372", "GTNH_2.8.0_Java_17-21.zip", []string{"MaxMemAlloc"}, []string{"JvmArgs", "JavaPath", "JavaVersion"}},
+		{"unknown Java", "", "GTNH_2.8.0_Java_17-21.zip", []string{"MaxMemAlloc"}, []string{"JvmArgs", "JavaPath"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := t.TempDir()
+			cfg := "[General]\nMaxMemAlloc=8192\nJvmArgs=-XX:+UseConcMarkSweepGC\nJavaPath=/usr/lib/jvm/java-8/bin/java\n" + tt.java + "\n"
+			if err := os.WriteFile(instanceConfigPath(src), []byte(cfg), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			sp, err := planInstanceSettings(src, filepath.Join(t.TempDir(), "new"), tt.release)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sp.Keys, tt.keys) || !reflect.DeepEqual(sp.LeftOut, tt.leftOut) {
+				t.Errorf("keys %v, left out %v; want %v, %v", sp.Keys, sp.LeftOut, tt.keys, tt.leftOut)
+			}
+			if (sp.JavaNote != "") != (tt.leftOut != nil) {
+				t.Errorf("java note %q", sp.JavaNote)
+			}
+		})
+	}
+}
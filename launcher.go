@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MultiMC and its forks keep instgroups.json in the instances folder and custom
+// icons in an icons folder next to it.
+func instanceGroupsPath(instancesDir string) string {
+	return filepath.Join(instancesDir, "instgroups.json")
+}
+
+func launcherIconsDir(instancesDir string) string {
+	return filepath.Join(filepath.Dir(instancesDir), "icons")
+}
+
+var iconExtensions = []string{".png", ".svg", ".jpg", ".jpeg", ".gif", ".ico"}
+
+// launcherPlan is the launcher metadata step of a migration plan.
+type launcherPlan struct {
+	IconKey    string `json:"iconKey,omitempty"`
+	IconFile   string `json:"iconFile,omitempty"`
+	GroupsFile string `json:"groupsFile,omitempty"`
+	Group      string `json:"group,omitempty"`
+}
+
+type launcherResult struct {
+	IconKey  string `json:"iconKey,omitempty"`
+	IconFile string `json:"iconFile,omitempty"`
+	Group    string `json:"group,omitempty"`
+}
+
+func planLauncherMetadata(source string) (*launcherPlan, error) {
+	lp := &launcherPlan{}
+	instancesDir := filepath.Dir(source)
+	if cfg, err := readInstanceConfig(instanceConfigPath(source)); err == nil {
+		if key, ok := cfg.get("iconKey"); ok && key != "" && key != "default" {
+			lp.IconKey = key
+			lp.IconFile = findIcon(key, launcherIconsDir(instancesDir), source)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	groupsFile := instanceGroupsPath(instancesDir)
+	groups, err := readInstanceGroups(groupsFile)
+	if os.IsNotExist(err) {
+		return lp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lp.GroupsFile = groupsFile
+	lp.Group = groups.groupOf(filepath.Base(source))
+	return lp, nil
+}
+
+// findIcon looks for a custom icon in the launcher's icons folder, then in the
+// instance folder, where exported instances carry it.
+func findIcon(key, iconsDir, instancePath string) string {
+	for _, dir := range []string{iconsDir, instancePath} {
+		for _, ext := range iconExtensions {
+			p := filepath.Join(dir, key+ext)
+			if pathExists(p) {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// applyLauncherMetadata gives the new instance the source's icon and puts it in
+// the source's group.
+func applyLauncherMetadata(lp *launcherPlan, destInstance string) (*launcherResult, error) {
+	if lp == nil {
+		return nil, nil
+	}
+	res := &launcherResult{}
+	if lp.IconKey != "" {
+		cfgPath := instanceConfigPath(destInstance)
+		cfg, err := readInstanceConfig(cfgPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.set("iconKey", lp.IconKey)
+		if err := cfg.write(cfgPath); err != nil {
+			return nil, err
+		}
+		res.IconKey = lp.IconKey
+
+		if lp.IconFile != "" {
+			// the launcher only shows icons from its icons folder; a copy in the
+			// instance folder travels with it when the instance is exported
+			name := filepath.Base(lp.IconFile)
+			iconsDir := launcherIconsDir(filepath.Dir(destInstance))
+			for _, dst := range []string{filepath.Join(iconsDir, name), filepath.Join(destInstance, name)} {
+				if pathExists(dst) {
+					continue
+				}
+				if err := copyFile(lp.IconFile, dst); err != nil {
+					return nil, fmt.Errorf("copy icon: %w", err)
+				}
+			}
+			res.IconFile = name
+		}
+	}
+
+	if lp.Group != "" {
+		if err := addToInstanceGroup(lp.GroupsFile, lp.Group, filepath.Base(destInstance)); err != nil {
+			return nil, fmt.Errorf("update %s: %w", filepath.Base(lp.GroupsFile), err)
+		}
+		res.Group = lp.Group
+	}
+	return res, nil
+}
+
+// instanceGroups is instgroups.json. Everything is kept as raw JSON apart from the
+// instance lists, so fields this tool does not know about survive a rewrite.
+type instanceGroups struct {
+	top    map[string]json.RawMessage
+	groups map[string]map[string]json.RawMessage
+}
+
+func readInstanceGroups(path string) (*instanceGroups, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	g := &instanceGroups{}
+	if err := json.Unmarshal(data, &g.top); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	g.groups = make(map[string]map[string]json.RawMessage)
+	if raw, ok := g.top["groups"]; ok {
+		if err := json.Unmarshal(raw, &g.groups); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+	return g, nil
+}
+
+func (g *instanceGroups) instances(group string) []string {
+	var ids []string
+	if raw, ok := g.groups[group]["instances"]; ok {
+		_ = json.Unmarshal(raw, &ids)
+	}
+	return ids
+}
+
+func (g *instanceGroups) groupOf(instanceID string) string {
+	for name := range g.groups {
+		for _, id := range g.instances(name) {
+			if id == instanceID {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// addToInstanceGroup moves instanceID into group, creating the group if needed.
+// The file is replaced atomically and the previous version kept as a .bak.
+func addToInstanceGroup(path, group, instanceID string) error {
+	g, err := readInstanceGroups(path)
+	if err != nil {
+		return err
+	}
+	for name, fields := range g.groups {
+		ids := g.instances(name)
+		kept := ids[:0]
+		for _, id := range ids {
+			if id != instanceID {
+				kept = append(kept, id)
+			}
+		}
+		if name == group {
+			kept = append(kept, instanceID)
+		}
+		raw, err := json.Marshal(kept)
+		if err != nil {
+			return err
+		}
+		fields["instances"] = raw
+	}
+	if _, ok := g.groups[group]; !ok {
+		raw, _ := json.Marshal([]string{instanceID})
+		g.groups[group] = map[string]json.RawMessage{"hidden": json.RawMessage("false"), "instances": raw}
+	}
+	rawGroups, err := json.Marshal(g.groups)
+	if err != nil {
+		return err
+	}
+	g.top["groups"] = rawGroups
+	if _, ok := g.top["formatVersion"]; !ok {
+		g.top["formatVersion"] = json.RawMessage(`"1"`)
+	}
+	data, err := json.Marshal(g.top)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "    "); err != nil {
+		return err
+	}
+	out.WriteString("\n")
+	return replaceFileAtomically(path, out.Bytes())
+}
+
+// replaceFileAtomically writes data next to path and renames it into place, so a
+// crash never leaves a half-written file. The old contents are kept as path.bak.
+func replaceFileAtomically(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if pathExists(path) {
+		if err := copyFile(path, path+".bak"); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmpName, path)
+}
+
+func (lp *launcherPlan) describe() string {
+	var parts []string
+	if lp.IconKey != "" {
+		icon := "icon " + lp.IconKey
+		if lp.IconFile != "" {
+			icon += " (" + lp.IconFile + ")"
+		}
+		parts = append(parts, icon)
+	}
+	if lp.Group != "" {
+		parts = append(parts, fmt.Sprintf("group %q in %s", lp.Group, lp.GroupsFile))
+	}
+	if len(parts) == 0 {
+		return "nothing to carry over"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplyLauncherMetadata(t *testing.T) {
+	const groups = `{"formatVersion": "1", "custom": 7, "groups": {` +
+		`"GTNH": {"hidden": true, "instances": ["old", "other"]}, ` +
+		`"Misc": {"hidden": false, "instances": ["new"]}}}`
+	tests := []struct {
+		name string
+		// iconDir is where the source's icon lives, relative to the launcher folder
+		iconDir string
+	}{
+		{"icon in the launcher's icons folder", "icons"},
+		{"icon in the instance folder", filepath.Join("instances", "old")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			launcher := t.TempDir()
+			instances := filepath.Join(launcher, "instances")
+			source, dest := filepath.Join(instances, "old"), filepath.Join(instances, "new")
+			for path, data := range map[string]string{
+				instanceConfigPath(source):                      "[General]\niconKey=gtnh\n",
+				instanceConfigPath(dest):                        "[General]\niconKey=default\nname=new\n",
+				filepath.Join(launcher, tt.iconDir, "gtnh.png"): "icon",
+				instanceGroupsPath(instances):                   groups,
+			} {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			lp, err := planLauncherMetadata(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lp.IconKey != "gtnh" || lp.IconFile != filepath.Join(launcher, tt.iconDir, "gtnh.png") || lp.Group != "GTNH" {
+				t.Fatalf("plan %+v, want icon gtnh from %s and group GTNH", lp, tt.iconDir)
+			}
+			res, err := applyLauncherMetadata(lp, dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.IconKey != "gtnh" || res.IconFile != "gtnh.png" || res.Group != "GTNH" {
+				t.Errorf("result %+v", res)
+			}
+
+			cfg, err := readInstanceConfig(instanceConfigPath(dest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key, _ := cfg.get("iconKey"); key != "gtnh" {
+				t.Errorf("iconKey = %q, want gtnh", key)
+			}
+			if name, _ := cfg.get("name"); name != "new" {
+				t.Errorf("name = %q, want it left alone", name)
+			}
+			for _, icon := range []string{filepath.Join(launcher, "icons", "gtnh.png"), filepath.Join(dest, "gtnh.png")} {
+				if data, err := os.ReadFile(icon); err != nil || string(data) != "icon" {
+					t.Errorf("%s: %q, %v", icon, data, err)
+				}
+			}
+
+			g, err := readInstanceGroups(instanceGroupsPath(instances))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := g.instances("GTNH"), []string{"old", "other", "new"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("GTNH holds %v, want %v", got, want)
+			}
+			if got := g.instances("Misc"); len(got) != 0 {
+				t.Errorf("Misc still holds %v", got)
+			}
+			// fields the tool does not know about survive the rewrite
+			if string(g.top["custom"]) != "7" || string(g.groups["GTNH"]["hidden"]) != "true" {
+				t.Errorf("unknown fields lost: custom %s, hidden %s", g.top["custom"], g.groups["GTNH"]["hidden"])
+			}
+			bak, err := os.ReadFile(instanceGroupsPath(instances) + ".bak")
+			if err != nil || string(bak) != groups {
+				t.Errorf("backup of the old file: %q, %v", bak, err)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 
@@ -11,6 +13,28 @@ import (
 )
 
 func main() {
+	opts, err := parseCLI(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	if opts.headless() {
+		if err := runHeadless(opts, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// the TUI saves settings as it goes; a config it cannot read must not be
+	// replaced by one holding only those
+	if _, err := loadConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
 	const defaultWidth = 40
 
 	l := list.New([]list.Item{}, itemDelegate{}, defaultWidth, listHeight)
@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 func copyFile(src, dst string) error {
+	return copyFileContext(context.Background(), src, dst, nil)
+}
+
+// copyFileContext copies src to dst in chunks, stopping when ctx is cancelled,
+// and gives the copy src's permissions and modification time. onBytes, when set,
+// is called with the size of every chunk written.
+func copyFileContext(ctx context.Context, src, dst string, onBytes func(int64)) error {
 	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
 		return err
 	}
@@ -16,34 +27,81 @@ func copyFile(src, dst string) error {
 		return err
 	}
 	defer sf.Close()
-	df, err := os.Create(dst)
+	info, err := sf.Stat()
 	if err != nil {
 		return err
 	}
-	defer df.Close()
-	_, err = io.Copy(df, sf)
-	return err
-}
-
-func copyDir(srcDir, dstDir string) error {
-	entries, err := os.ReadDir(srcDir)
+	df, err := os.Create(dst)
 	if err != nil {
 		return err
 	}
-	for _, e := range entries {
-		srcPath := filepath.Join(srcDir, e.Name())
-		dstPath := filepath.Join(dstDir, e.Name())
-		if e.IsDir() {
-			if err := copyDir(srcPath, dstPath); err != nil {
+	if err := copyContext(ctx, df, sf, onBytes); err != nil {
+		df.Close()
+		return err
+	}
+	if err := df.Close(); err != nil {
+		return err
+	}
+	keepMetadata(dst, info)
+	return nil
+}
+
+// keepMetadata gives dst the permissions and modification time of src, as far as
+// the filesystem allows; a copy that lost them is still a good copy.
+func keepMetadata(dst string, src fs.FileInfo) {
+	_ = os.Chmod(dst, src.Mode().Perm())
+	_ = os.Chtimes(dst, time.Time{}, src.ModTime())
+}
+
+// copyContext copies src to dst in chunks, stopping when ctx is cancelled.
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader, onBytes func(int64)) error {
+	buf := make([]byte, 1<<20)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
 				return err
 			}
-			continue
+			if onBytes != nil {
+				onBytes(int64(n))
+			}
 		}
-		if err := copyFile(srcPath, dstPath); err != nil {
-			return err
+		if rerr == io.EOF {
+			return nil
+		}
+		if rerr != nil {
+			return rerr
 		}
 	}
-	return nil
+}
+
+func copyDir(srcDir, dstDir string) error {
+	return newCopyEngine(nil).copyTree(context.Background(), srcDir, dstDir)
+}
+
+// readLines reads a text file and reports which line ending it uses.
+func readLines(path string) ([]string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	text := string(data)
+	newline := "\n"
+	if strings.Contains(text, "\r\n") {
+		newline = "\r\n"
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+	}
+	if text == "" {
+		return nil, newline, nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n"), newline, nil
+}
+
+func writeLines(path string, lines []string, newline string) error {
+	return os.WriteFile(path, []byte(strings.Join(lines, newline)+newline), 0o644)
 }
 
 func pathExists(p string) bool {
@@ -61,21 +119,37 @@ func firstExistingPath(rel string, roots []string) (string, bool) {
 	return "", false
 }
 
-func preferredDestinationPath(rel string, roots []string) string {
+// updaterDir is where the updater keeps its own files inside an instance.
+func updaterDir(instancePath string) string {
+	return filepath.Join(instancePath, ".gtnh-updater")
+}
+
+// instanceRoots lists the folders game data may live in, in order of preference.
+func instanceRoots(instancePath string) []string {
+	return []string{
+		filepath.Join(instancePath, ".minecraft"),
+		filepath.Join(instancePath, "minecraft"),
+		instancePath,
+	}
+}
+
+// destinationRoot picks the first of the instance's roots that exists. exists is
+// pathExists for an extracted instance, or a lookup into the archive layout when
+// planning ahead of the download.
+func destinationRoot(instancePath string, exists func(string) bool) string {
+	roots := instanceRoots(instancePath)
 	for _, root := range roots {
-		if pathExists(root) {
-			return filepath.Join(root, rel)
+		if exists(root) {
+			return root
 		}
 	}
-	if len(roots) == 0 {
-		return rel
-	}
-	return filepath.Join(roots[len(roots)-1], rel)
+	return roots[len(roots)-1]
 }
 
-// migrateInstance copies selected folders/files from source instance into destination instance
-func migrateInstance(sourceInstancePath, destinationInstancePath string) error {
-	toCopyDirs := []string{
+// migratedDirs and migratedFiles are the user data carried over to a new instance,
+// relative to the instance's game folder.
+var (
+	migratedDirs = []string{
 		"saves",
 		"backups",
 		"journeymap",
@@ -86,43 +160,238 @@ func migrateInstance(sourceInstancePath, destinationInstancePath string) error {
 		"shaderpacks",
 		"screenshots",
 	}
-	toCopyFiles := []string{
+	migratedFiles = []string{
 		"localconfig.cfg",
 		"BotaniaVars.dat",
 		"options.txt",
 		filepath.Join("serverutilities", "serverutilities.cfg"),
 	}
+)
+
+// migrateInstance transfers the entries of the plan from the source instance into
+// the destination instance. Files the new pack ships too are settled by their
+// entry's conflict policy first, asking ask where the policy is ask. Transfers
+// run on engine; plan.DestRoot must already be resolved against the extracted pack.
+func migrateInstance(ctx context.Context, plan *migrationPlan, report *migrationReport, engine *copyEngine, ask conflictAsker) error {
+	stats := make([]transferStats, len(plan.Entries))
+	for i, e := range plan.Entries {
+		dst := plan.destinationFor(e)
+		switch {
+		case e.Excluded:
+		case e.Kind == entryDir:
+			if err := engine.addTree(e.Path, e.Source, dst, e.Mode, &stats[i], e.Skip); err != nil {
+				return fmt.Errorf("scan dir %s: %w", e.Path, err)
+			}
+		case e.Link != "" && !engine.dereference:
+			engine.addLink(e.Path, e.Source, dst, e.Link, e.Mode, &stats[i])
+		default:
+			info, err := os.Stat(e.Source)
+			if err != nil {
+				return err
+			}
+			engine.addFile(e.Path, e.Source, dst, info.Size(), e.Mode, &stats[i])
+		}
+	}
+	if err := resolveConflicts(ctx, plan, engine, ask, report); err != nil {
+		return err
+	}
+	if err := engine.run(ctx); err != nil {
+		return fmt.Errorf("transfer user data: %w", err)
+	}
+	for i, e := range plan.Entries {
+		if stats[i].Files > 0 || stats[i].Filtered > 0 || len(stats[i].Notes) > 0 {
+			report.Transfers = append(report.Transfers, entryTransfer{Path: filepath.ToSlash(e.Path), Mode: e.Mode, transferStats: stats[i]})
+		}
+	}
+	return nil
+}
+
+// Stages reported while a migration runs.
+const (
+	stageBackingUp   = "Backing up worlds"
+	stageDownloading = "Downloading"
+	stageExtracting  = "Extracting"
+	stageCopying     = "Copying user data"
+	stageVerifying   = "Verifying copied files"
+	stagePruning     = "Dropping unvisited chunks"
+	stageCheckSource = "Checking the worlds' region files"
+	stageCheckCopy   = "Checking the copied region files"
+)
+
+// checkRegionsOf checks the region files of the worlds plan migrates.
+func checkRegionsOf(ctx context.Context, plan *migrationPlan, progress progressFunc) (*regionCheck, error) {
+	for _, e := range plan.Entries {
+		if e.Path == "saves" {
+			return checkRegions(ctx, e.Source, plan.skippedWorlds(), func(done, total int64) {
+				progress(stageCheckSource, done, total)
+			})
+		}
+	}
+	return nil, nil
+}
+
+// progressFunc receives the stage a migration is in and how far along it is, in
+// bytes, or in archive entries while extracting. total is -1 when unknown.
+type progressFunc func(stage string, done, total int64)
+
+// executeMigration downloads and extracts the planned release, transfers the
+// planned entries into it and merges the user's configs into the new pack's.
+// ask settles the file conflicts left to the user; nil fails on them. If
+// anything fails or ctx is cancelled the new instance is removed again, after
+// putting back anything that was moved out of the source. In-place plans are run
+// by executeUpgrade.
+func executeMigration(ctx context.Context, plan *migrationPlan, progress progressFunc, ask conflictAsker) (*migrationReport, error) {
+	if plan == nil {
+		return nil, fmt.Errorf("no migration plan")
+	}
+	if progress == nil {
+		progress = func(string, int64, int64) {}
+	}
+	if err := checkGameStopped(plan.Source); err != nil {
+		return nil, err
+	}
+	// before anything is downloaded, so a full disk is found out up front
+	if err := checkDiskSpace(plan); err != nil {
+		return nil, err
+	}
+	if plan.InPlace {
+		return executeUpgrade(ctx, plan, progress)
+	}
+	if !pathExists(plan.Source) {
+		return nil, fmt.Errorf("source instance not found: %s", plan.Source)
+	}
+	dest := plan.Destination
+	if err := checkDestinationFree(dest); err != nil {
+		return nil, err
+	}
+
+	backup, err := backupWorlds(ctx, plan.Backup, func(done, total int64) {
+		progress(stageBackingUp, done, total)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("back up worlds: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "gtnh-updater-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	zipPath, err := downloadVersionZip(ctx, plan.Release.URL, tmpDir, func(downloaded, total int64) {
+		progress(stageDownloading, downloaded, total)
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	sourceRoots := []string{
-		filepath.Join(sourceInstancePath, ".minecraft"),
-		filepath.Join(sourceInstancePath, "minecraft"),
-		sourceInstancePath,
+	if err = os.MkdirAll(dest, 0o755); err != nil {
+		return nil, err
 	}
-	destRoots := []string{
-		filepath.Join(destinationInstancePath, ".minecraft"),
-		filepath.Join(destinationInstancePath, "minecraft"),
-		destinationInstancePath,
+	engine := newCopyEngine(func(done, total int64) {
+		progress(stageCopying, done, total)
+	})
+	engine.dereference = plan.Dereference
+	if engine.exclude, err = compileExclusions(plan.Exclusions, time.Now()); err != nil {
+		return nil, err
 	}
+	cleanupDest := true
+	defer func() {
+		if cleanupDest {
+			if undoErr := engine.undoMoves(); undoErr != nil {
+				// leave the new instance alone rather than delete moved data
+				return
+			}
+			_ = os.RemoveAll(dest)
+		}
+	}()
 
-	for _, d := range toCopyDirs {
-		src, ok := firstExistingPath(d, sourceRoots)
-		if !ok {
-			continue
+	if err = extractZip(zipPath, dest, func(processed, total int, _ string) {
+		progress(stageExtracting, int64(processed), int64(total))
+	}); err != nil {
+		return nil, err
+	}
+
+	if err = maybeFlattenSingleDir(dest); err != nil {
+		return nil, err
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	plan.resolveDestRoot()
+	if err = snapshotConfigBaseline(dest, plan.DestRoot); err != nil {
+		return nil, fmt.Errorf("snapshot pack configs: %w", err)
+	}
+	if err = snapshotModsBaseline(dest, plan.DestRoot); err != nil {
+		return nil, fmt.Errorf("snapshot pack mods: %w", err)
+	}
+	report := &migrationReport{Destination: dest, Backup: backup}
+	if plan.included("saves") {
+		report.Worlds = plan.Worlds
+	}
+	regionsChecked := plan.CheckRegions && plan.included("saves")
+	if regionsChecked {
+		// before the worlds are moved or copied, so damage found afterwards can be
+		// told apart from damage that was already there
+		report.Regions = &regionChecks{}
+		report.Regions.Source, err = checkRegionsOf(ctx, plan, progress)
+		if err != nil {
+			return nil, fmt.Errorf("check region files: %w", err)
 		}
-		dst := preferredDestinationPath(d, destRoots)
-		if err := copyDir(src, dst); err != nil {
-			return fmt.Errorf("copy dir %s: %w", d, err)
+	}
+	if err = migrateInstance(ctx, plan, report, engine, ask); err != nil {
+		return nil, err
+	}
+	if plan.Verify {
+		report.Verification, err = engine.verify(ctx, func(done, total int64) {
+			progress(stageVerifying, done, total)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("verify copied files: %w", err)
+		}
+		if !report.Verification.worldDataIntact() {
+			return nil, worldVerificationError(report.Verification)
+		}
+	}
+	if cp := plan.ChunkPrune; cp != nil && cp.Enabled && plan.included("saves") && !plan.savesMoved() && !plan.savesLinked() {
+		// after verification, which compares the copy with the untouched source
+		report.ChunkPrune, err = scanUnvisitedChunks(ctx, filepath.Join(plan.DestRoot, "saves"), cp.MaxTicks, true, nil, func(done, total int64) {
+			progress(stagePruning, done, total)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("drop unvisited chunks: %w", err)
 		}
 	}
-	for _, f := range toCopyFiles {
-		src, ok := firstExistingPath(f, sourceRoots)
-		if !ok {
-			continue
+	if regionsChecked {
+		report.Regions.Destination, err = checkRegions(ctx, filepath.Join(plan.DestRoot, "saves"), plan.skippedWorlds(), func(done, total int64) {
+			progress(stageCheckCopy, done, total)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("check copied region files: %w", err)
 		}
-		dst := preferredDestinationPath(f, destRoots)
-		if err := copyFile(src, dst); err != nil {
-			return fmt.Errorf("copy file %s: %w", f, err)
+		if added := report.Regions.Destination.newProblems(report.Regions.Source); len(added) > 0 {
+			return nil, &regionDamageError{Problems: added}
 		}
 	}
-	return nil
+	if plan.included("saves") {
+		if err = checkWorldCopies(plan.Worlds, filepath.Join(plan.DestRoot, "saves")); err != nil {
+			return nil, err
+		}
+	}
+	if report.ConfigMerge, err = mergeInstanceConfigs(plan.ConfigMerge, plan.DestRoot); err != nil {
+		return nil, err
+	}
+	if report.Mods, err = diffMods(plan.Mods, plan.DestRoot); err != nil {
+		return nil, fmt.Errorf("compare mods: %w", err)
+	}
+	if report.Settings, err = applyInstanceSettings(plan.Settings, dest); err != nil {
+		return nil, fmt.Errorf("carry over instance settings: %w", err)
+	}
+	if report.Launcher, err = applyLauncherMetadata(plan.Launcher, dest); err != nil {
+		return nil, fmt.Errorf("carry over launcher metadata: %w", err)
+	}
+
+	cleanupDest = false
+	return report, nil
 }
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// modJar is a jar in a mods folder, identified by its mcmod.info when it has one.
+type modJar struct {
+	File    string `json:"file"`
+	ID      string `json:"id"`
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type modUpdate struct {
+	ID      string `json:"id"`
+	Old     modJar `json:"old"`
+	New     modJar `json:"new"`
+	Changed string `json:"changed"`
+}
+
+// userMod is a jar from the source instance that was not part of the old pack.
+// Bundled is set when the new pack ships the same mod.
+type userMod struct {
+	Jar     modJar  `json:"jar"`
+	Source  string  `json:"source"`
+	Bundled *modJar `json:"bundled,omitempty"`
+	Warning string  `json:"warning,omitempty"`
+	// Verified is false when the old pack's mod list was not recorded and the jar
+	// may simply be a mod the pack dropped.
+	Verified bool `json:"verified"`
+}
+
+// recommended reports whether the jar should be copied unless the user says otherwise.
+func (u userMod) recommended() bool {
+	return u.Verified && u.Warning == ""
+}
+
+type modDiff struct {
+	Added    []modJar    `json:"added,omitempty"`
+	Removed  []modJar    `json:"removed,omitempty"`
+	Updated  []modUpdate `json:"updated,omitempty"`
+	UserMods []userMod   `json:"userMods,omitempty"`
+	Copied   []string    `json:"copied,omitempty"`
+}
+
+// modsPlan is the mod comparison step of a migration plan.
+type modsPlan struct {
+	SourceDir    string `json:"sourceDir"`
+	BaselineFile string `json:"baselineFile"`
+	HasBaseline  bool   `json:"hasBaseline"`
+}
+
+func modsBaselineFile(instancePath string) string {
+	return filepath.Join(updaterDir(instancePath), "baseline", "mods.json")
+}
+
+func planModDiff(source string) *modsPlan {
+	src, ok := firstExistingPath("mods", instanceRoots(source))
+	if !ok {
+		return nil
+	}
+	baseline := modsBaselineFile(source)
+	return &modsPlan{SourceDir: src, BaselineFile: baseline, HasBaseline: pathExists(baseline)}
+}
+
+// snapshotModsBaseline records the jar names the freshly extracted pack ships so a
+// later upgrade can tell the user's own mods apart from the pack's.
+func snapshotModsBaseline(instancePath, gameRoot string) error {
+	jars, err := listJars(filepath.Join(gameRoot, "mods"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	path := modsBaselineFile(instancePath)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(jars, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// diffMods compares the source instance's mods with the new pack's and finds the
+// jars the user added themselves.
+func diffMods(mp *modsPlan, gameRoot string) (*modDiff, error) {
+	if mp == nil {
+		return nil, nil
+	}
+	oldMods, err := scanMods(mp.SourceDir)
+	if err != nil {
+		return nil, err
+	}
+	newMods, err := scanMods(filepath.Join(gameRoot, "mods"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var packJars map[string]bool
+	if mp.HasBaseline {
+		data, err := os.ReadFile(mp.BaselineFile)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		if err := json.Unmarshal(data, &names); err != nil {
+			return nil, fmt.Errorf("read %s: %w", mp.BaselineFile, err)
+		}
+		packJars = make(map[string]bool, len(names))
+		for _, n := range names {
+			packJars[n] = true
+		}
+	}
+
+	oldByID := indexMods(oldMods)
+	newByID := indexMods(newMods)
+	diff := &modDiff{}
+	for _, m := range newMods {
+		old, ok := oldByID[m.ID]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, m)
+		case old.File != m.File || old.Version != m.Version:
+			diff.Updated = append(diff.Updated, modUpdate{ID: m.ID, Old: old, New: m, Changed: describeModChange(old, m)})
+		}
+	}
+	for _, m := range oldMods {
+		bundled, inNew := newByID[m.ID]
+		if !inNew {
+			diff.Removed = append(diff.Removed, m)
+		}
+
+		userAdded := !inNew
+		if packJars != nil {
+			userAdded = !packJars[m.File]
+		}
+		if !userAdded {
+			continue
+		}
+		um := userMod{Jar: m, Source: filepath.Join(mp.SourceDir, m.File), Verified: packJars != nil}
+		if inNew {
+			b := bundled
+			um.Bundled = &b
+			if compareModVersions(bundled.Version, m.Version) >= 0 {
+				um.Warning = fmt.Sprintf("the new pack already bundles %s %s", bundled.File, bundled.Version)
+			} else {
+				um.Warning = fmt.Sprintf("replaces the bundled older %s", bundled.File)
+			}
+		}
+		diff.UserMods = append(diff.UserMods, um)
+	}
+	return diff, nil
+}
+
+// copyUserMods copies the chosen user mods into the new pack's mods folder. A jar
+// that replaces a bundled copy of the same mod moves the bundled jar aside so the
+// game does not load both.
+func copyUserMods(mods []userMod, destInstance, gameRoot string) ([]string, error) {
+	modsDir := filepath.Join(gameRoot, "mods")
+	var copied []string
+	for _, um := range mods {
+		if um.Bundled != nil {
+			aside := filepath.Join(updaterDir(destInstance), "replaced-mods", um.Bundled.File)
+			if err := os.MkdirAll(filepath.Dir(aside), 0o755); err != nil {
+				return copied, err
+			}
+			if err := os.Rename(filepath.Join(modsDir, um.Bundled.File), aside); err != nil && !os.IsNotExist(err) {
+				return copied, fmt.Errorf("move bundled %s aside: %w", um.Bundled.File, err)
+			}
+		}
+		if err := copyFile(um.Source, filepath.Join(modsDir, um.Jar.File)); err != nil {
+			return copied, fmt.Errorf("copy %s: %w", um.Jar.File, err)
+		}
+		copied = append(copied, um.Jar.File)
+	}
+	return copied, nil
+}
+
+func listJars(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var jars []string
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if !e.IsDir() && (ext == ".jar" || ext == ".zip") {
+			jars = append(jars, e.Name())
+		}
+	}
+	sort.Strings(jars)
+	return jars, nil
+}
+
+func scanMods(dir string) ([]modJar, error) {
+	jars, err := listJars(dir)
+	if err != nil {
+		return nil, err
+	}
+	mods := make([]modJar, 0, len(jars))
+	for _, name := range jars {
+		mods = append(mods, readModJar(filepath.Join(dir, name)))
+	}
+	return mods, nil
+}
+
+func indexMods(mods []modJar) map[string]modJar {
+	byID := make(map[string]modJar, len(mods))
+	for _, m := range mods {
+		if _, dup := byID[m.ID]; !dup {
+			byID[m.ID] = m
+		}
+	}
+	return byID
+}
+
+type mcmodEntry struct {
+	ModID   string `json:"modid"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// readModJar identifies a jar by the first entry of its mcmod.info, falling back
+// to its file name when the jar has none or it does not parse.
+func readModJar(path string) modJar {
+	file := filepath.Base(path)
+	m := modJar{File: file}
+	m.ID, m.Version = modIDFromFileName(file)
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return m
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.Name != "mcmod.info" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return m
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, 1<<20))
+		rc.Close()
+		if err != nil {
+			return m
+		}
+		if entry, ok := parseMcmodInfo(data); ok {
+			if entry.ModID != "" {
+				m.ID = strings.ToLower(entry.ModID)
+			}
+			m.Name = entry.Name
+			if v := strings.TrimSpace(entry.Version); v != "" && !strings.Contains(v, "${") {
+				m.Version = v
+			}
+		}
+		return m
+	}
+	return m
+}
+
+// parseMcmodInfo accepts both mcmod.info layouts: a bare list of mods and the
+// {"modList": [...]} form.
+func parseMcmodInfo(data []byte) (mcmodEntry, bool) {
+	var list []mcmodEntry
+	if err := json.Unmarshal(data, &list); err == nil && len(list) > 0 {
+		return list[0], true
+	}
+	var wrapped struct {
+		ModList []mcmodEntry `json:"modList"`
+	}
+	if err := json.Unmarshal(data, &wrapped); err == nil && len(wrapped.ModList) > 0 {
+		return wrapped.ModList[0], true
+	}
+	return mcmodEntry{}, false
+}
+
+var modFileVersion = regexp.MustCompile(`^(.*?)[-_ +]+[vV]?(\d.*)$`)
+
+func modIDFromFileName(file string) (id, version string) {
+	base := strings.TrimSuffix(file, filepath.Ext(file))
+	if m := modFileVersion.FindStringSubmatch(base); m != nil && m[1] != "" {
+		return strings.ToLower(m[1]), m[2]
+	}
+	return strings.ToLower(base), ""
+}
+
+func describeModChange(old, next modJar) string {
+	if old.Version != "" && next.Version != "" && old.Version != next.Version {
+		return old.Version + " -> " + next.Version
+	}
+	return old.File + " -> " + next.File
+}
+
+// compareModVersions compares dotted versions piece by piece, numerically where
+// both pieces are numbers.
+func compareModVersions(a, b string) int {
+	split := func(s string) []string {
+		return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z')
+		})
+	}
+	ap, bp := split(a), split(b)
+	for i := 0; i < len(ap) || i < len(bp); i++ {
+		if i >= len(ap) {
+			return -1
+		}
+		if i >= len(bp) {
+			return 1
+		}
+		an, aerr := strconv.Atoi(ap[i])
+		bn, berr := strconv.Atoi(bp[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				if an > bn {
+					return 1
+				}
+				return -1
+			}
+		case ap[i] != bp[i]:
+			return strings.Compare(ap[i], bp[i])
+		}
+	}
+	return 0
+}
+
+func userModChecklist(mods []userMod) checklist {
+	var c checklist
+	for _, um := range mods {
+		var notes []string
+		if um.Warning != "" {
+			notes = append(notes, "warning: "+um.Warning)
+		}
+		if !um.Verified {
+			notes = append(notes, "may be a mod the pack dropped")
+		}
+		c.items = append(c.items, checkItem{label: um.Jar.File, detail: strings.Join(notes, "; "), checked: um.recommended()})
+	}
+	return c
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffMods(t *testing.T) {
+	// the jars have no mcmod.info, so they are told apart by their file names
+	oldPack := []string{"core-1.0.jar", "gone-1.0.jar", "nei-2.5.jar"}
+	userJars := []string{"minimap-2.0.jar", "nei-2.4.jar", "waila-1.0.jar"}
+	newPack := []string{"core-1.1.jar", "fresh-1.0.jar", "minimap-1.5.jar", "nei-2.5.jar"}
+	jars := func(dir string, names []string) {
+		t.Helper()
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range names {
+			if err := os.WriteFile(filepath.Join(dir, n), nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	files := func(mods []modJar) []string {
+		var out []string
+		for _, m := range mods {
+			out = append(out, m.File)
+		}
+		return out
+	}
+	type wantUserMod struct {
+		file        string
+		bundled     string
+		recommended bool
+	}
+	tests := []struct {
+		name     string
+		baseline bool
+		userMods []wantUserMod
+	}{
+		{
+			name:     "with the old pack's mod list",
+			baseline: true,
+			userMods: []wantUserMod{
+				// both clash with a jar the new pack bundles, so neither is copied unasked
+				{"minimap-2.0.jar", "minimap-1.5.jar", false},
+				{"nei-2.4.jar", "nei-2.5.jar", false},
+				{"waila-1.0.jar", "", true},
+			},
+		},
+		{
+			name: "without it every jar the new pack lacks is a guess",
+			userMods: []wantUserMod{
+				{"gone-1.0.jar", "", false},
+				{"waila-1.0.jar", "", false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source, dest := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+			jars(filepath.Join(source, "mods"), oldPack)
+			if tt.baseline {
+				if err := snapshotModsBaseline(source, source); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// the user swaps the pack's nei for an older one and adds two mods
+			if err := os.Remove(filepath.Join(source, "mods", "nei-2.5.jar")); err != nil {
+				t.Fatal(err)
+			}
+			jars(filepath.Join(source, "mods"), userJars)
+			jars(filepath.Join(dest, "mods"), newPack)
+
+			mp := planModDiff(source)
+			if mp == nil || mp.HasBaseline != tt.baseline {
+				t.Fatalf("planModDiff = %+v, want a plan with baseline %v", mp, tt.baseline)
+			}
+			diff, err := diffMods(mp, dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := files(diff.Added), []string{"fresh-1.0.jar"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("added %v, want %v", got, want)
+			}
+			if got, want := files(diff.Removed), []string{"gone-1.0.jar", "waila-1.0.jar"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("removed %v, want %v", got, want)
+			}
+			var updated []string
+			for _, u := range diff.Updated {
+				updated = append(updated, u.ID+" "+u.Changed)
+			}
+			if want := []string{"core 1.0 -> 1.1", "minimap 2.0 -> 1.5", "nei 2.4 -> 2.5"}; !reflect.DeepEqual(updated, want) {
+				t.Errorf("updated %v, want %v", updated, want)
+			}
+			if len(diff.UserMods) != len(tt.userMods) {
+				t.Fatalf("user mods %+v, want %+v", diff.UserMods, tt.userMods)
+			}
+			for i, want := range tt.userMods {
+				um := diff.UserMods[i]
+				bundled := ""
+				if um.Bundled != nil {
+					bundled = um.Bundled.File
+				}
+				if um.Jar.File != want.file || bundled != want.bundled || um.recommended() != want.recommended || um.Verified != tt.baseline {
+					t.Errorf("user mod %d = %s bundled %q recommended %v verified %v, want %+v", i, um.Jar.File, bundled, um.recommended(), um.Verified, want)
+				}
+			}
+		})
+	}
+}
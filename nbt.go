@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// NBT tag types, as Minecraft numbers them.
+const (
+	tagEnd byte = iota
+	tagByte
+	tagShort
+	tagInt
+	tagLong
+	tagFloat
+	tagDouble
+	tagByteArray
+	tagString
+	tagList
+	tagCompound
+	tagIntArray
+	tagLongArray
+)
+
+const (
+	nbtMaxDepth = 512
+	// nbtMaxLen bounds array and list lengths so a damaged file cannot make the
+	// reader allocate gigabytes.
+	nbtMaxLen = 1 << 24
+)
+
+// nbtCompound is a decoded compound tag. Values are int8, int16, int32, int64,
+// float32, float64, []byte, string, []any, nbtCompound, []int32 or []int64.
+type nbtCompound map[string]any
+
+func (c nbtCompound) compound(name string) nbtCompound {
+	v, _ := c[name].(nbtCompound)
+	return v
+}
+
+func (c nbtCompound) str(name string) string {
+	v, _ := c[name].(string)
+	return v
+}
+
+// int returns a numeric tag of any integer width.
+func (c nbtCompound) int(name string) (int64, bool) {
+	switch v := c[name].(type) {
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+func (c nbtCompound) list(name string) []any {
+	v, _ := c[name].([]any)
+	return v
+}
+
+// readNBTFile reads a whole NBT file such as level.dat, gzip-compressed or not.
+func readNBTFile(path string) (nbtCompound, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return readNBT(r)
+}
+
+// readNBT decodes an uncompressed NBT stream whose root is a compound.
+func readNBT(r io.Reader) (nbtCompound, error) {
+	d := nbtDecoder{r: r}
+	typ, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	if typ != tagCompound {
+		return nil, fmt.Errorf("nbt: root is tag %d, not a compound", typ)
+	}
+	if _, err := d.string(); err != nil {
+		return nil, err
+	}
+	v, err := d.payload(tagCompound, 0)
+	if err != nil {
+		return nil, err
+	}
+	return v.(nbtCompound), nil
+}
+
+type nbtDecoder struct {
+	r   io.Reader
+	buf [8]byte
+}
+
+var errNBTTooLong = errors.New("nbt: length out of range")
+
+func (d *nbtDecoder) read(n int) ([]byte, error) {
+	if _, err := io.ReadFull(d.r, d.buf[:n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("nbt: %w", err)
+	}
+	return d.buf[:n], nil
+}
+
+func (d *nbtDecoder) byte() (byte, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *nbtDecoder) length() (int, error) {
+	b, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	n := int32(binary.BigEndian.Uint32(b))
+	if n < 0 || n > nbtMaxLen {
+		return 0, errNBTTooLong
+	}
+	return int(n), nil
+}
+
+func (d *nbtDecoder) string() (string, error) {
+	b, err := d.read(2)
+	if err != nil {
+		return "", err
+	}
+	s := make([]byte, binary.BigEndian.Uint16(b))
+	if _, err := io.ReadFull(d.r, s); err != nil {
+		return "", fmt.Errorf("nbt: %w", io.ErrUnexpectedEOF)
+	}
+	return string(s), nil
+}
+
+func (d *nbtDecoder) payload(typ byte, depth int) (any, error) {
+	if depth > nbtMaxDepth {
+		return nil, errors.New("nbt: nested too deeply")
+	}
+	switch typ {
+	case tagByte:
+		b, err := d.byte()
+		return int8(b), err
+	case tagShort:
+		b, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		return int16(binary.BigEndian.Uint16(b)), nil
+	case tagInt:
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return int32(binary.BigEndian.Uint32(b)), nil
+	case tagLong:
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case tagFloat:
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), nil
+	case tagDouble:
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case tagByteArray:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, d.r, int64(n)); err != nil {
+			return nil, fmt.Errorf("nbt: %w", io.ErrUnexpectedEOF)
+		}
+		return buf.Bytes(), nil
+	case tagString:
+		return d.string()
+	case tagList:
+		elem, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 && (elem == tagEnd || elem > tagLongArray) {
+			return nil, fmt.Errorf("nbt: list of unknown tag %d", elem)
+		}
+		var list []any
+		for i := 0; i < n; i++ {
+			v, err := d.payload(elem, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case tagCompound:
+		c := make(nbtCompound)
+		for {
+			t, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			if t == tagEnd {
+				return c, nil
+			}
+			name, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			if c[name], err = d.payload(t, depth+1); err != nil {
+				return nil, err
+			}
+		}
+	case tagIntArray:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		var a []int32
+		for i := 0; i < n; i++ {
+			b, err := d.read(4)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, int32(binary.BigEndian.Uint32(b)))
+		}
+		return a, nil
+	case tagLongArray:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		var a []int64
+		for i := 0; i < n; i++ {
+			b, err := d.read(8)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, int64(binary.BigEndian.Uint64(b)))
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("nbt: unknown tag %d", typ)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// writeNBTPayload appends v's payload to buf and returns its tag type. It covers
+// the types the tests use.
+func writeNBTPayload(buf *bytes.Buffer, v any) byte {
+	switch v := v.(type) {
+	case int8:
+		buf.WriteByte(byte(v))
+		return tagByte
+	case int32:
+		binary.Write(buf, binary.BigEndian, v)
+		return tagInt
+	case int64:
+		binary.Write(buf, binary.BigEndian, v)
+		return tagLong
+	case string:
+		binary.Write(buf, binary.BigEndian, uint16(len(v)))
+		buf.WriteString(v)
+		return tagString
+	case []byte:
+		binary.Write(buf, binary.BigEndian, int32(len(v)))
+		buf.Write(v)
+		return tagByteArray
+	case nbtCompound:
+		for name, e := range v {
+			var p bytes.Buffer
+			buf.WriteByte(writeNBTPayload(&p, e))
+			writeNBTPayload(buf, name)
+			buf.Write(p.Bytes())
+		}
+		buf.WriteByte(tagEnd)
+		return tagCompound
+	}
+	panic("nbt test encoder: unsupported value")
+}
+
+// encodeNBT encodes c as an unnamed root compound.
+func encodeNBT(c nbtCompound) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(tagCompound)
+	writeNBTPayload(&buf, "")
+	writeNBTPayload(&buf, c)
+	return buf.Bytes()
+}
+
+func TestReadNBT(t *testing.T) {
+	want := nbtCompound{
+		"Level": nbtCompound{"InhabitedTime": int64(1234), "xPos": int32(-3), "Biomes": []byte{1, 2, 3}},
+		"name":  "world",
+		"flag":  int8(1),
+	}
+	got, err := readNBT(bytes.NewReader(encodeNBT(want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readNBT = %v, want %v", got, want)
+	}
+}
+
+func TestReadNBTRejects(t *testing.T) {
+	valid := encodeNBT(nbtCompound{"Level": nbtCompound{"InhabitedTime": int64(7), "name": "x"}})
+	deep := []byte{tagCompound, 0, 0}
+	for i := 0; i <= nbtMaxDepth+1; i++ {
+		deep = append(deep, tagCompound, 0, 1, 'c')
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, io.ErrUnexpectedEOF},
+		{"root is not a compound", []byte{tagInt, 0, 0, 0, 0, 0, 1}, nil},
+		{"negative array length", []byte{tagCompound, 0, 0, tagByteArray, 0, 1, 'a', 0xff, 0xff, 0xff, 0xff}, errNBTTooLong},
+		{"array longer than the limit", []byte{tagCompound, 0, 0, tagIntArray, 0, 1, 'a', 0x7f, 0, 0, 0}, errNBTTooLong},
+		{"list of an unknown tag", []byte{tagCompound, 0, 0, tagList, 0, 1, 'a', 99, 0, 0, 0, 1}, nil},
+		{"unknown tag", []byte{tagCompound, 0, 0, 42, 0, 1, 'a', 0}, nil},
+		{"nested too deeply", deep, nil},
+		{"string past the end", []byte{tagCompound, 0, 0, tagString, 0, 1, 'a', 0, 9, 'x'}, io.ErrUnexpectedEOF},
+	}
+	for n := 1; n < len(valid); n++ {
+		tests = append(tests, struct {
+			name string
+			data []byte
+			want error
+		}{"truncated", valid[:n], io.ErrUnexpectedEOF})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readNBT(bytes.NewReader(tt.data))
+			if err == nil {
+				t.Fatalf("readNBT(% x) succeeded", tt.data)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("readNBT(% x) = %v, want %v", tt.data, err, tt.want)
+			}
+		})
+	}
+}
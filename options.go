@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fileMerger combines the user's copy of a migrated file with the one the new
+// pack ships, writing the result over newPath.
+type fileMerger func(userPath, newPath string) (fileMergeResult, error)
+
+type fileMergeResult struct {
+	Path  string `json:"path"`
+	Kept  int    `json:"kept"`
+	Added int    `json:"added"`
+	// Extra are the user's keys the new file lacks, kept at its end.
+	Extra []string `json:"extra,omitempty"`
+	// Dropped are the user's keys of mods the new pack no longer has.
+	Dropped []string `json:"dropped,omitempty"`
+}
+
+// fileMergers lists the migrated files that are merged key by key instead of
+// copied over the new pack's version.
+var fileMergers = map[string]fileMerger{
+	"options.txt": mergeOptionsFile,
+}
+
+// optionMod is the mod an options.txt key belongs to, or "" for the game's own
+// keys and keys that do not say. Mods name their key bindings key.<modid>.<action>,
+// which options.txt saves as key_key.<modid>.<action>.
+func optionMod(key string) string {
+	name, ok := strings.CutPrefix(key, "key_key.")
+	if !ok {
+		return ""
+	}
+	mod, _, ok := strings.Cut(name, ".")
+	if !ok || mod == "hotbar" {
+		return ""
+	}
+	return strings.ToLower(mod)
+}
+
+// mergeOptionsFile merges Minecraft's options.txt. The new pack's file decides
+// the order; the user's values win for keys present in both. Keys only the user's
+// file has are kept after the new pack's, since mods often add theirs only once
+// they run, unless they belong to a mod missing from the new mods folder; those
+// are dropped. Both are reported.
+func mergeOptionsFile(userPath, newPath string) (fileMergeResult, error) {
+	var res fileMergeResult
+	installed := make(map[string]bool)
+	mods, err := scanMods(filepath.Join(filepath.Dir(newPath), "mods"))
+	if err != nil && !os.IsNotExist(err) {
+		return res, err
+	}
+	for _, m := range mods {
+		installed[m.ID] = true
+	}
+	userLines, _, err := readLines(userPath)
+	if err != nil {
+		return res, err
+	}
+	newLines, newline, err := readLines(newPath)
+	if err != nil {
+		return res, err
+	}
+
+	userValues := make(map[string]string, len(userLines))
+	var userKeys []string
+	for _, line := range userLines {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if _, dup := userValues[key]; !dup {
+			userKeys = append(userKeys, key)
+		}
+		userValues[key] = value
+	}
+
+	seen := make(map[string]bool, len(newLines))
+	merged := make([]string, 0, len(newLines))
+	for _, line := range newLines {
+		key, _, ok := strings.Cut(line, ":")
+		if !ok {
+			merged = append(merged, line)
+			continue
+		}
+		seen[key] = true
+		if value, found := userValues[key]; found {
+			merged = append(merged, key+":"+value)
+			res.Kept++
+			continue
+		}
+		merged = append(merged, line)
+		res.Added++
+	}
+	for _, key := range userKeys {
+		if seen[key] {
+			continue
+		}
+		if mod := optionMod(key); mod != "" && !installed[mod] {
+			res.Dropped = append(res.Dropped, key)
+			continue
+		}
+		merged = append(merged, key+":"+userValues[key])
+		res.Extra = append(res.Extra, key)
+	}
+	sort.Strings(res.Extra)
+	sort.Strings(res.Dropped)
+	return res, writeLines(newPath, merged, newline)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeModJar writes a jar whose mcmod.info names modid.
+func writeModJar(t *testing.T, path, modid string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("mcmod.info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(`[{"modid": "` + modid + `", "version": "1.0"}]`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeOptionsFile(t *testing.T) {
+	dir := t.TempDir()
+	user := filepath.Join(dir, "user", "options.txt")
+	next := filepath.Join(dir, "new", "options.txt")
+	writeCfg(t, user, "fov:0.5", "key_key.forward:17", "key_key.journeymap.map:36", "key_key.thaumcraft.wand:33", "key_key.hotbar.1:2", "customOption:on")
+	writeCfg(t, next, "fov:0.0", "guiScale:2", "key_key.forward:17")
+	writeModJar(t, filepath.Join(dir, "new", "mods", "journeymap-5.2.jar"), "JourneyMap")
+
+	res, err := mergeOptionsFile(user, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"fov:0.5", "guiScale:2", "key_key.forward:17", "key_key.journeymap.map:36", "key_key.hotbar.1:2", "customOption:on"}
+	if got := strings.Split(strings.TrimSpace(string(data)), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged options.txt %q, want %q", got, want)
+	}
+	if res.Kept != 2 || res.Added != 1 {
+		t.Errorf("kept %d, added %d; want 2, 1", res.Kept, res.Added)
+	}
+	if want := []string{"customOption", "key_key.hotbar.1", "key_key.journeymap.map"}; !reflect.DeepEqual(res.Extra, want) {
+		t.Errorf("extra %v, want %v", res.Extra, want)
+	}
+	if want := []string{"key_key.thaumcraft.wand"}; !reflect.DeepEqual(res.Dropped, want) {
+		t.Errorf("dropped %v, want %v", res.Dropped, want)
+	}
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type entryKind string
+
+const (
+	entryDir  entryKind = "dir"
+	entryFile entryKind = "file"
+)
+
+// What happens to a plan entry. Merged files fall back to a copy when the new pack
+// does not ship the file.
+const (
+	actionCopy  = "copy"
+	actionMerge = "merge"
+)
+
+// Where the destination root of a plan came from.
+const (
+	destRootFromArchive   = "archive"
+	destRootAssumed       = "assumed"
+	destRootFromExtracted = "extracted"
+)
+
+// migrationPlan describes everything executeMigration will do. The dry run prints
+// it and the real run executes the very same plan.
+type migrationPlan struct {
+	Source         string      `json:"source"`
+	Destination    string      `json:"destination"`
+	Release        releasePlan `json:"release"`
+	DestRoot       string      `json:"destRoot"`
+	DestRootSource string      `json:"destRootSource"`
+	Entries        []planEntry `json:"entries"`
+	Verify         bool        `json:"verify"`
+	InPlace        bool        `json:"inPlace,omitempty"`
+	Replace        []planEntry `json:"replace,omitempty"`
+	// Rollbacks prunes the older rollbacks of an in-place upgrade.
+	Rollbacks    *retentionPolicy `json:"rollbackRetention,omitempty"`
+	Backup       *backupPlan      `json:"backup,omitempty"`
+	Worlds       []worldInfo      `json:"worlds,omitempty"`
+	ChunkPrune   *chunkPrunePlan  `json:"chunkPrune,omitempty"`
+	CheckRegions bool             `json:"checkRegions,omitempty"`
+	// Dereference copies what symbolic links point to instead of recreating them.
+	Dereference bool `json:"dereference,omitempty"`
+	// Exclusions leave files inside the folder entries behind.
+	Exclusions  []exclusionRule       `json:"exclusions,omitempty"`
+	ConfigMerge *configMergePlan      `json:"configMerge,omitempty"`
+	Mods        *modsPlan             `json:"mods,omitempty"`
+	Settings    *instanceSettingsPlan `json:"instanceSettings,omitempty"`
+	Launcher    *launcherPlan         `json:"launcher,omitempty"`
+}
+
+type releasePlan struct {
+	Version  string `json:"version"`
+	FileName string `json:"fileName"`
+	URL      string `json:"url"`
+	Size     int64  `json:"size"`
+	// Uncompressed is the extracted size, from the archive's central directory.
+	Uncompressed int64 `json:"uncompressed,omitempty"`
+}
+
+type planEntry struct {
+	Path   string       `json:"path"`
+	Kind   entryKind    `json:"kind"`
+	Action string       `json:"action"`
+	Mode   transferMode `json:"mode"`
+	// Conflict settles the files the new pack ships too.
+	Conflict conflictPolicy `json:"conflict,omitempty"`
+	Source   string         `json:"source"`
+	Files    int            `json:"files"`
+	Bytes    int64          `json:"bytes"`
+	// Skip lists files under Source, relative to it, that are not carried over.
+	Skip []string `json:"skip,omitempty"`
+	// Excluded leaves the whole entry behind.
+	Excluded bool `json:"excluded,omitempty"`
+	// Filtered and FilteredBytes are the files under Source the exclusion rules
+	// leave out; Files and Bytes do not count them.
+	Filtered      int   `json:"filtered,omitempty"`
+	FilteredBytes int64 `json:"filteredBytes,omitempty"`
+	// Link is where Source points when it is a symbolic link.
+	Link string `json:"link,omitempty"`
+}
+
+// buildMigrationPlan checks the inputs and works out the release, the extraction
+// target and every entry migrateInstance will copy, without changing anything on disk.
+func buildMigrationPlan(source, dest, version string) (*migrationPlan, error) {
+	if source == "" {
+		return nil, fmt.Errorf("source instance path is empty")
+	}
+	if !pathExists(source) {
+		return nil, fmt.Errorf("source instance not found: %s", source)
+	}
+	if version == "" || version == "No versions available" {
+		return nil, fmt.Errorf("no GTNH version selected")
+	}
+	if err := checkDestinationFree(dest); err != nil {
+		return nil, err
+	}
+
+	release, err := probeRelease(version)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	plan := &migrationPlan{Source: source, Destination: dest, Release: release, Verify: cfg == nil || !cfg.SkipVerification, Dereference: cfg != nil && cfg.DereferenceSymlinks, Exclusions: cfg.exclusions()}
+
+	if zr, err := openRemoteZip(release.URL, release.Size); err == nil {
+		plan.Release.Uncompressed = zipUncompressedSize(zr.File)
+		layout := zipLayout(zr.File)
+		plan.DestRoot = destinationRoot(dest, func(p string) bool {
+			rel, err := filepath.Rel(dest, p)
+			if err != nil {
+				return false
+			}
+			return rel == "." || layout[filepath.ToSlash(rel)]
+		})
+		plan.DestRootSource = destRootFromArchive
+	} else {
+		// GTNH MultiMC archives ship a .minecraft folder
+		plan.DestRoot = filepath.Join(dest, ".minecraft")
+		plan.DestRootSource = destRootAssumed
+	}
+
+	sourceRoots := instanceRoots(source)
+	for _, d := range migratedDirs {
+		src, ok := firstExistingPath(d, sourceRoots)
+		if !ok {
+			continue
+		}
+		conflict, err := cfg.conflictPolicy(d)
+		if err != nil {
+			return nil, err
+		}
+		e := planEntry{Path: d, Kind: entryDir, Action: actionCopy, Mode: cfg.transferMode(d), Conflict: conflict, Source: src, Excluded: cfg.excluded(d)}
+		e.Link, _ = os.Readlink(src)
+		if d == "backups" {
+			// the game's own backups are rotated instead of carried over wholesale
+			if e.Skip, _, err = retainedSkips(src, cfg.retention()); err != nil {
+				return nil, fmt.Errorf("apply retention to %s: %w", d, err)
+			}
+		}
+		if err := plan.countEntry(&e); err != nil {
+			return nil, err
+		}
+		plan.Entries = append(plan.Entries, e)
+	}
+	for _, f := range migratedFiles {
+		src, ok := firstExistingPath(f, sourceRoots)
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		conflict, err := cfg.conflictPolicy(f)
+		if err != nil {
+			return nil, err
+		}
+		action := actionCopy
+		if conflict == conflictMerge {
+			action = actionMerge
+		}
+		e := planEntry{Path: f, Kind: entryFile, Action: action, Mode: modeCopy, Conflict: conflict, Source: src, Files: 1, Bytes: info.Size(), Excluded: cfg.excluded(f)}
+		e.Link, _ = os.Readlink(src)
+		plan.Entries = append(plan.Entries, e)
+	}
+	if plan.Backup, err = planBackup(source, cfg); err != nil {
+		return nil, err
+	}
+	for _, e := range plan.Entries {
+		if e.Path == "saves" {
+			if plan.Worlds, err = planWorlds(e.Source, packVersion(release.FileName)); err != nil {
+				return nil, fmt.Errorf("read worlds: %w", err)
+			}
+			plan.ChunkPrune = &chunkPrunePlan{Enabled: cfg != nil && cfg.PruneChunks, MaxTicks: cfg.pruneTicks()}
+			plan.CheckRegions = true
+			if cfg != nil {
+				// worlds deleted since are forgotten
+				var skip []string
+				for _, n := range cfg.SkippedWorlds[instanceKey(source)] {
+					for _, w := range plan.Worlds {
+						if w.Folder == n {
+							skip = append(skip, n)
+						}
+					}
+				}
+				if err = plan.skipWorlds(skip); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	plan.ConfigMerge = planConfigMerge(source)
+	plan.Mods = planModDiff(source)
+	if plan.Settings, err = planInstanceSettings(source, dest, release.FileName); err != nil {
+		return nil, fmt.Errorf("read instance settings: %w", err)
+	}
+	if plan.Launcher, err = planLauncherMetadata(source); err != nil {
+		return nil, fmt.Errorf("read launcher metadata: %w", err)
+	}
+	return plan, nil
+}
+
+// resolveDestRoot re-evaluates the destination root against the extracted pack so
+// the copy lands where the launcher expects it even if the prediction was off.
+func (p *migrationPlan) resolveDestRoot() {
+	p.DestRoot = destinationRoot(p.Destination, pathExists)
+	p.DestRootSource = destRootFromExtracted
+}
+
+// setMode changes the transfer mode of the folder entry at path.
+func (p *migrationPlan) setMode(path string, mode transferMode) error {
+	for i, e := range p.Entries {
+		if e.Path == path && e.Kind == entryDir {
+			p.Entries[i].Mode = mode
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a folder in the migration plan", path)
+}
+
+func (p *migrationPlan) dirEntries() []planEntry {
+	var dirs []planEntry
+	for _, e := range p.Entries {
+		if e.Kind == entryDir && !e.Excluded {
+			dirs = append(dirs, e)
+		}
+	}
+	return dirs
+}
+
+func (p *migrationPlan) destinationFor(e planEntry) string {
+	return filepath.Join(p.DestRoot, e.Path)
+}
+
+// excludeEntries leaves the entries at paths behind and carries over the rest.
+func (p *migrationPlan) excludeEntries(paths []string) {
+	for i := range p.Entries {
+		p.Entries[i].Excluded = false
+		for _, path := range paths {
+			if p.Entries[i].Path == path {
+				p.Entries[i].Excluded = true
+			}
+		}
+	}
+}
+
+// savesMoved reports whether the worlds are moved, leaving no source copy that a
+// change to the destination would spare.
+func (p *migrationPlan) savesMoved() bool {
+	for _, e := range p.Entries {
+		if e.Path == "saves" {
+			return e.Mode == modeMove
+		}
+	}
+	return false
+}
+
+// countEntry works out the files and bytes the folder entry e carries over, and
+// those the exclusion rules leave out.
+func (p *migrationPlan) countEntry(e *planEntry) error {
+	x, err := compileExclusions(p.Exclusions, time.Now())
+	if err != nil {
+		return err
+	}
+	files, bytes, err := treeStats(e.Source, p.Dereference)
+	if err != nil {
+		return fmt.Errorf("scan %s: %w", e.Path, err)
+	}
+	for _, s := range e.Skip {
+		f, b, err := treeStats(filepath.Join(e.Source, filepath.FromSlash(s)), p.Dereference)
+		if err != nil {
+			return fmt.Errorf("scan %s: %w", e.Path, err)
+		}
+		files, bytes = files-f, bytes-b
+	}
+	if e.Filtered, e.FilteredBytes, err = x.excludedIn(e.Path, e.Source, p.Dereference, e.Skip); err != nil {
+		return fmt.Errorf("scan %s: %w", e.Path, err)
+	}
+	e.Files, e.Bytes = files-e.Filtered, bytes-e.FilteredBytes
+	return nil
+}
+
+// recount counts every folder entry again after a change to how they are walked.
+func (p *migrationPlan) recount() error {
+	for i := range p.Entries {
+		if p.Entries[i].Kind == entryDir {
+			if err := p.countEntry(&p.Entries[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setDereference switches between recreating symbolic links and copying what
+// they point to.
+func (p *migrationPlan) setDereference(on bool) error {
+	p.Dereference = on
+	return p.recount()
+}
+
+// addExclusions adds rules to the ones from the config.
+func (p *migrationPlan) addExclusions(rules []exclusionRule) error {
+	p.Exclusions = append(p.Exclusions, rules...)
+	return p.recount()
+}
+
+// savesLinked reports whether the new instance's saves folder is a link to the
+// source's, so the worlds there are the originals.
+func (p *migrationPlan) savesLinked() bool {
+	for _, e := range p.Entries {
+		if e.Path == "saves" {
+			return e.Link != "" && !p.Dereference
+		}
+	}
+	return false
+}
+
+// included reports whether entry path is carried over.
+func (p *migrationPlan) included(path string) bool {
+	for _, e := range p.Entries {
+		if e.Path == path {
+			return !e.Excluded
+		}
+	}
+	return false
+}
+
+func (p *migrationPlan) totals() (files int, bytes int64) {
+	for _, e := range p.Entries {
+		if e.Excluded {
+			continue
+		}
+		files += e.Files
+		bytes += e.Bytes
+	}
+	return files, bytes
+}
+
+// writeText renders the plan for humans; the TUI summary and the plain dry-run
+// output share it.
+func (p *migrationPlan) writeText(w io.Writer) {
+	if p.InPlace {
+		p.writeUpgradeText(w)
+	} else {
+		p.writeMigrationText(w)
+	}
+
+	if len(p.Worlds) > 0 {
+		fmt.Fprintln(w, "Worlds:")
+		for _, wi := range p.Worlds {
+			if wi.Skip || !p.InPlace && !p.included("saves") {
+				fmt.Fprintf(w, "  %s  (not migrated)\n", wi.describe())
+				continue
+			}
+			fmt.Fprintf(w, "  %s\n", wi.describe())
+			if wi.Newer {
+				fmt.Fprintf(w, "    warning: last played with GTNH %s, newer than %s; it may not load or may lose items\n", wi.PackVersion, packVersion(p.Release.FileName))
+			}
+		}
+	}
+
+	if cp := p.ChunkPrune; cp != nil && p.included("saves") {
+		switch {
+		case !cp.Enabled:
+			fmt.Fprintln(w, "Prune chunks: off")
+		case p.savesMoved():
+			fmt.Fprintln(w, "Prune chunks: skipped, the worlds are moved rather than copied")
+		case p.savesLinked():
+			fmt.Fprintln(w, "Prune chunks: skipped, the saves folder is a link to the original worlds")
+		default:
+			fmt.Fprintf(w, "Prune chunks: drop chunks players spent less than %s near from the copied worlds; the source keeps them\n", cp.threshold())
+		}
+		if p.CheckRegions {
+			fmt.Fprintln(w, "Region check: read every chunk of the worlds before the migration and again after; damage the copy adds fails it")
+		} else {
+			fmt.Fprintln(w, "Region check: skipped")
+		}
+	}
+
+	if b := p.Backup; b != nil {
+		if b.Enabled {
+			into := "into a zip in " + b.Dir
+			if b.Format != backupFormatZip {
+				into = "into the backup store in " + backupStoreDir(b.Dir) + ", only what changed since the last snapshot"
+			}
+			fmt.Fprintf(w, "Back up: %s (%d files, %s) %s first\n", strings.Join(b.Entries, ", "), b.Files, formatBytes(b.Bytes), into)
+			if b.Retention.active() {
+				fmt.Fprintln(w, "         then remove older backups of this instance the retention policy does not keep")
+			}
+		} else {
+			fmt.Fprintln(w, "Back up: skipped")
+		}
+	}
+
+	if cm := p.ConfigMerge; cm != nil {
+		baseline := "baseline found"
+		if !cm.HasBaseline {
+			baseline = "no baseline from the old pack, your values win wherever the new pack still has the key"
+		}
+		fmt.Fprintf(w, "Merge configs: %s into the new config folder (%s)\n", cm.SourceDir, baseline)
+	}
+	if mp := p.Mods; mp != nil {
+		baseline := "old pack mod list found"
+		if !mp.HasBaseline {
+			baseline = "old pack mod list not recorded, your own mods cannot be told apart from dropped pack mods"
+		}
+		fmt.Fprintf(w, "Compare mods: %s with the new pack (%s)\n", mp.SourceDir, baseline)
+	}
+	if sp := p.Settings; sp != nil {
+		keys := "no launcher settings to copy"
+		if len(sp.Keys) > 0 {
+			keys = "copy " + strings.Join(sp.Keys, ", ")
+		}
+		fmt.Fprintf(w, "Instance settings: %s from %s, name the instance %q\n", keys, sp.SourceFile, sp.Name)
+		if len(sp.LeftOut) > 0 {
+			fmt.Fprintf(w, "  leave out %s: %s; pick the Java in the launcher\n", strings.Join(sp.LeftOut, ", "), sp.JavaNote)
+		}
+	}
+	if lp := p.Launcher; lp != nil {
+		fmt.Fprintf(w, "Launcher: %s\n", lp.describe())
+	}
+
+	if needs, err := p.spaceNeeded(); err != nil {
+		fmt.Fprintf(w, "\nDisk space: cannot tell (%v)\n", err)
+	} else if len(needs) > 0 {
+		fmt.Fprintln(w, "\nDisk space:")
+		for _, n := range needs {
+			for _, line := range strings.Split(n.describe(), "\n") {
+				fmt.Fprintln(w, "  "+line)
+			}
+			if n.short() {
+				fmt.Fprintln(w, "  warning: not enough room, the migration will refuse to start")
+			}
+		}
+	}
+}
+
+func (p *migrationPlan) releaseSize() string {
+	if p.Release.Size < 0 {
+		return "unknown size"
+	}
+	return formatBytes(p.Release.Size)
+}
+
+func (p *migrationPlan) writeUpgradeText(w io.Writer) {
+	fmt.Fprintf(w, "Upgrade in place: %s\n", p.Destination)
+	fmt.Fprintf(w, "Release:     %s (%s)\n", p.Release.FileName, p.releaseSize())
+	fmt.Fprintf(w, "             %s\n", p.Release.URL)
+	fmt.Fprintf(w, "Stage in:    %s\n", filepath.Join(updaterDir(p.Destination), "staging"))
+	fmt.Fprintf(w, "Game folder: %s\n\n", p.DestRoot)
+	for _, e := range p.Replace {
+		if e.Source == "" {
+			fmt.Fprintf(w, "  %-8s %-4s  %-24s (not in the instance yet)\n", e.Action, e.Kind, e.Path)
+			continue
+		}
+		fmt.Fprintf(w, "  %-8s %-4s  %-24s %7d files %10s\n", e.Action, e.Kind, e.Path, e.Files, formatBytes(e.Bytes))
+	}
+	fmt.Fprintf(w, "\nRollback: everything replaced is moved to %s first\n", filepath.Join(rollbackDir(p.Destination), "<date>"))
+	if p.Rollbacks != nil {
+		fmt.Fprintf(w, "          then remove older rollbacks the retention policy does not keep (the last %d without one)\n", defaultRollbacksKept)
+	}
+	fmt.Fprintln(w, "Your worlds, screenshots, options and launcher settings stay where they are.")
+}
+
+func (p *migrationPlan) writeMigrationText(w io.Writer) {
+	fmt.Fprintf(w, "Source:      %s\n", p.Source)
+	fmt.Fprintf(w, "Destination: %s\n", p.Destination)
+	fmt.Fprintf(w, "Release:     %s (%s)\n", p.Release.FileName, p.releaseSize())
+	fmt.Fprintf(w, "             %s\n", p.Release.URL)
+	fmt.Fprintf(w, "Extract to:  %s\n", p.Destination)
+	root := p.DestRoot
+	if p.DestRootSource == destRootAssumed {
+		root += " (assumed, archive listing unavailable)"
+	}
+	fmt.Fprintf(w, "Copy into:   %s\n\n", root)
+
+	if len(p.Entries) == 0 {
+		fmt.Fprintln(w, "Nothing to copy from the source instance.")
+	} else {
+		for _, e := range p.Entries {
+			if e.Excluded {
+				fmt.Fprintf(w, "  %-8s %-4s  %-24s %7d files %10s  not migrated\n", "skip", e.Kind, filepath.ToSlash(e.Path), e.Files, formatBytes(e.Bytes))
+				continue
+			}
+			how := string(e.Mode)
+			if e.Action == actionMerge {
+				how = actionMerge
+			}
+			fmt.Fprintf(w, "  %-8s %-4s  %-24s %7d files %10s  from %s%s%s\n", how, e.Kind, filepath.ToSlash(e.Path), e.Files, formatBytes(e.Bytes), e.Source, modeNote(e.Mode), conflictNote(e.Conflict))
+			if len(e.Skip) > 0 && e.Path == "saves" {
+				fmt.Fprintf(w, "  %-8s %-4s  %-24s %7d worlds left out: %s\n", "", "", "", len(e.Skip), strings.Join(e.Skip, ", "))
+			} else if len(e.Skip) > 0 {
+				fmt.Fprintf(w, "  %-8s %-4s  %-24s %7d files left behind by the retention policy\n", "", "", "", len(e.Skip))
+			}
+			if e.Filtered > 0 {
+				fmt.Fprintf(w, "  %-8s %-4s  %-24s %7d files left out by exclusion rules (%s)\n", "", "", "", e.Filtered, formatBytes(e.FilteredBytes))
+			}
+			if e.Link != "" && p.Dereference {
+				fmt.Fprintf(w, "  %-8s %-4s  %-24s %7s links to %s; what it points to is copied\n", "", "", "", "", e.Link)
+			} else if e.Link != "" {
+				fmt.Fprintf(w, "  %-8s %-4s  %-24s %7s links to %s; recreated as a link where the new pack has nothing in its place\n", "", "", "", "", e.Link)
+			}
+		}
+		files, bytes := p.totals()
+		fmt.Fprintf(w, "\nTotal: %d files, %s\n", files, formatBytes(bytes))
+		if len(p.Exclusions) > 0 {
+			rules := make([]string, len(p.Exclusions))
+			for i, r := range p.Exclusions {
+				rules[i] = r.describe()
+			}
+			fmt.Fprintf(w, "Exclude: %s\n", strings.Join(rules, ", "))
+		}
+		if p.Dereference {
+			fmt.Fprintln(w, "Symlinks: followed; what they point to is copied")
+		} else {
+			fmt.Fprintln(w, "Symlinks: recreated as links")
+		}
+	}
+
+	if p.Verify {
+		fmt.Fprintln(w, "\nVerify: compare every copied file with its source; the migration fails if world data differs")
+	} else {
+		fmt.Fprintln(w, "\nVerify: skipped")
+	}
+
+}
+
+func modeNote(m transferMode) string {
+	switch m {
+	case modeHardlink:
+		return " (shared with the source, changes show up in both)"
+	case modeMove:
+		return " (removed from the source)"
+	}
+	return ""
+}
+
+func (p *migrationPlan) String() string {
+	var b strings.Builder
+	p.writeText(&b)
+	return b.String()
+}
+
+// probeRelease resolves the download URL of version and asks the server for its size.
+// Size is -1 when the server does not report it.
+func probeRelease(version string) (releasePlan, error) {
+	downloadURL, fileName, err := resolveVersionURL(version)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	resp, err := http.Head(downloadURL)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return releasePlan{}, fmt.Errorf("release not available: %s", resp.Status)
+	}
+	return releasePlan{Version: version, FileName: fileName, URL: downloadURL, Size: resp.ContentLength}, nil
+}
+
+func checkDestinationFree(dest string) error {
+	if dest == "" {
+		return fmt.Errorf("destination instance path is empty")
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("destination already exists: %s", dest)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("unable to access destination: %w", err)
+	}
+	return nil
+}
+
+// dirStats counts the files under root and their bytes; symbolic links count as
+// files without bytes.
+func dirStats(root string) (files int, bytes int64, err error) {
+	return treeStats(root, false)
+}
+
+// treeStats is dirStats, counting what links point to when follow is set.
+func treeStats(root string, follow bool) (files int, bytes int64, err error) {
+	err = walkTree(root, follow, func(_ string, info fs.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
+		files++
+		if info.Mode().IsRegular() {
+			bytes += info.Size()
+		}
+		return nil
+	}, nil)
+	return files, bytes, err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
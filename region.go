@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Anvil region files hold 32x32 chunks. The first sector lists where each chunk
+// is, the second when it was last saved; chunk data follows in 4 KiB sectors,
+// each chunk prefixed with its length and compression.
+const (
+	regionChunks = 1024
+	regionWidth  = 32
+)
+
+// Chunk compression schemes.
+const (
+	chunkGzip byte = 1
+	chunkZlib byte = 2
+	chunkNone byte = 3
+)
+
+type regionFile struct {
+	path string
+	// X and Z are the region's coordinates from its file name.
+	X, Z int
+	data []byte
+}
+
+var errRegionName = errors.New("not named like a region file")
+
+func readRegionFile(path string) (*regionFile, error) {
+	r := &regionFile{path: path}
+	parts := strings.Split(filepath.Base(path), ".")
+	if len(parts) != 4 || parts[0] != "r" {
+		return nil, errRegionName
+	}
+	var err error
+	if r.X, err = strconv.Atoi(parts[1]); err != nil {
+		return nil, errRegionName
+	}
+	if r.Z, err = strconv.Atoi(parts[2]); err != nil {
+		return nil, errRegionName
+	}
+	if r.data, err = os.ReadFile(path); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// location returns where chunk i starts and how many sectors it has; zero
+// sectors means the chunk was never generated.
+func (r *regionFile) location(i int) (offset, sectors int) {
+	if len(r.data) < regionHeaderSize {
+		return 0, 0
+	}
+	loc := binary.BigEndian.Uint32(r.data[i*4:])
+	return int(loc >> 8), int(loc & 0xff)
+}
+
+// coords turns a chunk index into world chunk coordinates.
+func (r *regionFile) coords(i int) (x, z int) {
+	return r.X*regionWidth + i%regionWidth, r.Z*regionWidth + i/regionWidth
+}
+
+// Reasons a chunk cannot be read.
+var (
+	errChunkOutOfBounds = errors.New("chunk points outside the file")
+	errChunkInHeader    = errors.New("chunk points into the header")
+	errChunkLength      = errors.New("chunk length does not fit its sectors")
+	errChunkCompression = errors.New("unknown chunk compression")
+)
+
+// raw returns the sectors of chunk i after checking they lie inside the file.
+func (r *regionFile) raw(i int) ([]byte, error) {
+	offset, sectors := r.location(i)
+	if sectors == 0 {
+		return nil, nil
+	}
+	start, end := offset*regionSectorSize, (offset+sectors)*regionSectorSize
+	if offset < 2 {
+		return nil, errChunkInHeader
+	}
+	if start >= len(r.data) {
+		return nil, errChunkOutOfBounds
+	}
+	if end > len(r.data) {
+		// the game does not pad the last chunk of a file
+		end = len(r.data)
+	}
+	return r.data[start:end], nil
+}
+
+// chunk decodes chunk i. A chunk that was never generated is nil without error.
+func (r *regionFile) chunk(i int) (nbtCompound, error) {
+	raw, err := r.raw(i)
+	if raw == nil || err != nil {
+		return nil, err
+	}
+	if len(raw) < 5 {
+		return nil, errChunkLength
+	}
+	length := int(binary.BigEndian.Uint32(raw))
+	if length < 1 || length+4 > len(raw) {
+		return nil, errChunkLength
+	}
+	payload := bytes.NewReader(raw[5 : 4+length])
+	var rd io.Reader
+	switch raw[4] {
+	case chunkGzip:
+		zr, err := gzip.NewReader(payload)
+		if err != nil {
+			return nil, fmt.Errorf("decompress: %w", err)
+		}
+		defer zr.Close()
+		rd = zr
+	case chunkZlib:
+		zr, err := zlib.NewReader(payload)
+		if err != nil {
+			return nil, fmt.Errorf("decompress: %w", err)
+		}
+		defer zr.Close()
+		rd = zr
+	case chunkNone:
+		rd = payload
+	default:
+		return nil, errChunkCompression
+	}
+	// decompress first, so damaged compressed data is not reported as bad NBT
+	data, err := io.ReadAll(io.LimitReader(rd, nbtMaxLen))
+	if err != nil {
+		return nil, fmt.Errorf("decompress: %w", err)
+	}
+	return readNBT(bytes.NewReader(data))
+}
+
+// inhabitedTime is how many ticks players have spent near the chunk.
+func inhabitedTime(c nbtCompound) (int64, bool) {
+	if level := c.compound("Level"); level != nil {
+		return level.int("InhabitedTime")
+	}
+	return c.int("InhabitedTime")
+}
+
+// rewrite writes the region with only the chunks keep says to keep, packed
+// together, to a new file that then replaces the old one. Writing a new file
+// rather than changing the old one in place keeps a hardlinked or cloned source
+// untouched. A region left without chunks is deleted.
+func (r *regionFile) rewrite(keep func(i int) bool) error {
+	if len(r.data) < regionHeaderSize {
+		return errors.New("region header is truncated")
+	}
+	out := make([]byte, regionHeaderSize, len(r.data))
+	kept := 0
+	for i := 0; i < regionChunks; i++ {
+		raw, err := r.raw(i)
+		if raw == nil || err != nil || !keep(i) {
+			continue
+		}
+		sectors := (len(raw) + regionSectorSize - 1) / regionSectorSize
+		offset := len(out) / regionSectorSize
+		binary.BigEndian.PutUint32(out[i*4:], uint32(offset)<<8|uint32(sectors))
+		copy(out[regionSectorSize+i*4:], r.data[regionSectorSize+i*4:regionSectorSize+i*4+4])
+		out = append(out, raw...)
+		out = append(out, make([]byte, sectors*regionSectorSize-len(raw))...)
+		kept++
+	}
+	if kept == 0 {
+		return os.Remove(r.path)
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// regionFiles lists the .mca files under dir, in every dimension.
+func regionFiles(dir string) ([]string, error) {
+	var files []string
+	err := walkTree(dir, false, func(p string, info fs.FileInfo) error {
+		// linked region files belong to some other folder; leave them be
+		if info.Mode().IsRegular() && strings.HasSuffix(info.Name(), ".mca") && filepath.Base(filepath.Dir(p)) == "region" {
+			files = append(files, p)
+		}
+		return nil
+	}, nil)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return files, err
+}
+
+// eachRegion calls fn for every path on copyWorkers goroutines and returns the
+// first error. fn must guard any state it shares.
+func eachRegion(ctx context.Context, paths []string, progress func(done, total int64), fn func(path string) error) error {
+	var (
+		once     sync.Once
+		wg       sync.WaitGroup
+		done     atomic.Int64
+		firstErr error
+	)
+	queue := make(chan string)
+	for w := 0; w < copyWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range queue {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := fn(path); err != nil {
+					once.Do(func() { firstErr = fmt.Errorf("%s: %w", path, err) })
+				}
+				if progress != nil {
+					progress(done.Add(1), int64(len(paths)))
+				}
+			}
+		}()
+	}
+	for _, p := range paths {
+		queue <- p
+	}
+	close(queue)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// regionWorld is the world folder under savesDir a region file belongs to.
+func regionWorld(savesDir, path string) string {
+	rel, err := filepath.Rel(savesDir, path)
+	if err != nil {
+		return ""
+	}
+	return strings.Split(filepath.ToSlash(rel), "/")[0]
+}
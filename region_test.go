@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// chunkData frames c the way a region file stores a chunk: length, compression
+// and the payload.
+func chunkData(c nbtCompound, compression byte) []byte {
+	payload := encodeNBT(c)
+	if compression == chunkZlib {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(payload)
+		zw.Close()
+		payload = buf.Bytes()
+	}
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+1))
+	return append(append(out, compression), payload...)
+}
+
+// writeRegion writes r.0.0.mca with the chunks at their indexes, each stamped
+// with its index as the save time. Like the game, the last chunk is not padded.
+func writeRegion(t *testing.T, dir string, chunks map[int][]byte) string {
+	t.Helper()
+	var order []int
+	for i := range chunks {
+		order = append(order, i)
+	}
+	sort.Ints(order)
+	data := make([]byte, regionHeaderSize)
+	for _, i := range order {
+		if len(data)%regionSectorSize != 0 {
+			data = append(data, make([]byte, regionSectorSize-len(data)%regionSectorSize)...)
+		}
+		sectors := (len(chunks[i]) + regionSectorSize - 1) / regionSectorSize
+		binary.BigEndian.PutUint32(data[i*4:], uint32(len(data)/regionSectorSize)<<8|uint32(sectors))
+		binary.BigEndian.PutUint32(data[regionSectorSize+i*4:], uint32(i+1))
+		data = append(data, chunks[i]...)
+	}
+	path := filepath.Join(dir, "r.0.0.mca")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// visited is a chunk players spent ticks near, with a payload of size bytes.
+func visited(ticks int64, size int) nbtCompound {
+	return nbtCompound{"Level": nbtCompound{"InhabitedTime": ticks, "Blocks": bytes.Repeat([]byte{7}, size)}}
+}
+
+func TestRegionRewrite(t *testing.T) {
+	chunks := map[int]nbtCompound{
+		0:    visited(10, 100),
+		37:   visited(20, 3*regionSectorSize),
+		500:  visited(30, 100),
+		1023: visited(40, 2*regionSectorSize),
+	}
+	tests := []struct {
+		name string
+		keep []int
+	}{
+		{"keep all", []int{0, 37, 500, 1023}},
+		{"keep the oversized ones", []int{37, 1023}},
+		{"keep the last", []int{1023}},
+		{"keep none", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := make(map[int][]byte)
+			for i, c := range chunks {
+				// uncompressed, so the big chunks span several sectors
+				raw[i] = chunkData(c, chunkNone)
+				if i == 0 {
+					raw[i] = chunkData(c, chunkZlib)
+				}
+			}
+			path := writeRegion(t, t.TempDir(), raw)
+			r, err := readRegionFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keep := make(map[int]bool)
+			for _, i := range tt.keep {
+				keep[i] = true
+			}
+			if err := r.rewrite(func(i int) bool { return keep[i] }); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.keep) == 0 {
+				if pathExists(path) {
+					t.Fatal("a region without chunks was not removed")
+				}
+				return
+			}
+			if r, err = readRegionFile(path); err != nil {
+				t.Fatal(err)
+			}
+			if len(r.data)%regionSectorSize != 0 {
+				t.Errorf("rewritten region is %d bytes, not whole sectors", len(r.data))
+			}
+			for i := 0; i < regionChunks; i++ {
+				got, err := r.chunk(i)
+				if err != nil {
+					t.Fatalf("chunk %d: %v", i, err)
+				}
+				want := chunks[i]
+				if !keep[i] {
+					want = nil
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("chunk %d = %v, want %v", i, got != nil, want != nil)
+				}
+				if stamp := binary.BigEndian.Uint32(r.data[regionSectorSize+i*4:]); want != nil && stamp != uint32(i+1) {
+					t.Errorf("chunk %d save time %d, want %d", i, stamp, i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestScanRegion(t *testing.T) {
+	tests := []struct {
+		name          string
+		chunks        map[int][]byte
+		drop          bool
+		wantChunks    int
+		wantUnvisited int
+		wantDropped   int
+		wantLeft      []int
+	}{
+		{
+			name: "drops chunks below the threshold",
+			chunks: map[int][]byte{
+				0: chunkData(visited(0, 10), chunkZlib),
+				1: chunkData(visited(1199, 10), chunkZlib),
+				2: chunkData(visited(1200, 10), chunkZlib),
+				3: chunkData(nbtCompound{"Level": nbtCompound{}}, chunkZlib),
+			},
+			drop:       true,
+			wantChunks: 4, wantUnvisited: 2, wantDropped: 2,
+			wantLeft: []int{2, 3},
+		},
+		{
+			name:       "only counts without drop",
+			chunks:     map[int][]byte{5: chunkData(visited(0, 10), chunkZlib), 6: chunkData(visited(5000, 10), chunkNone)},
+			wantChunks: 2, wantUnvisited: 1,
+			wantLeft: []int{5, 6},
+		},
+		{
+			name: "keeps a region with an unreadable chunk",
+			chunks: map[int][]byte{
+				0: chunkData(visited(0, 10), chunkZlib),
+				1: {0, 0, 0, 2, 9, 0},
+			},
+			drop:       true,
+			wantChunks: 2, wantUnvisited: 1,
+			wantLeft: []int{0, 1},
+		},
+		{
+			name:       "removes a region left empty",
+			chunks:     map[int][]byte{8: chunkData(visited(3, 10), chunkZlib)},
+			drop:       true,
+			wantChunks: 1, wantUnvisited: 1, wantDropped: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeRegion(t, t.TempDir(), tt.chunks)
+			res, err := scanRegion(path, defaultPruneTicks, tt.drop)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Chunks != tt.wantChunks || res.Unvisited != tt.wantUnvisited || res.Dropped != tt.wantDropped {
+				t.Errorf("chunks %d, unvisited %d, dropped %d; want %d, %d, %d", res.Chunks, res.Unvisited, res.Dropped, tt.wantChunks, tt.wantUnvisited, tt.wantDropped)
+			}
+			var left []int
+			if r, err := readRegionFile(path); err == nil {
+				for i := 0; i < regionChunks; i++ {
+					if _, sectors := r.location(i); sectors > 0 {
+						left = append(left, i)
+					}
+				}
+			} else if !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(left, tt.wantLeft) {
+				t.Errorf("chunks left %v, want %v", left, tt.wantLeft)
+			}
+		})
+	}
+}
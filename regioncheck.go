@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// chunkProblem is one damaged chunk, or a region file too damaged to find its
+// chunks in.
+type chunkProblem struct {
+	// File is the region file relative to the saves folder.
+	File string `json:"file"`
+	// X and Z are the chunk's coordinates; unset when Region is.
+	X int `json:"x"`
+	Z int `json:"z"`
+	// Region is set when the whole file is unreadable.
+	Region  bool   `json:"region,omitempty"`
+	Problem string `json:"problem"`
+}
+
+func (p chunkProblem) describe() string {
+	if p.Region {
+		return fmt.Sprintf("%s: %s", p.File, p.Problem)
+	}
+	return fmt.Sprintf("%s chunk %d,%d (block %d,%d): %s", p.File, p.X, p.Z, p.X*16, p.Z*16, p.Problem)
+}
+
+func (p chunkProblem) key() string {
+	return fmt.Sprintf("%s %d %d %t", p.File, p.X, p.Z, p.Region)
+}
+
+// regionCheck is the outcome of reading every chunk of a saves folder.
+type regionCheck struct {
+	Regions  int            `json:"regions"`
+	Chunks   int            `json:"chunks"`
+	Problems []chunkProblem `json:"problems,omitempty"`
+}
+
+// regionChecks are the checks of the source worlds before the migration and of
+// the copies after it.
+type regionChecks struct {
+	Source      *regionCheck `json:"source,omitempty"`
+	Destination *regionCheck `json:"destination,omitempty"`
+}
+
+var errRegionHeader = errors.New("header is truncated")
+
+// check reads every chunk of the region: its place in the header, whether its
+// sectors lie inside the file without overlapping another chunk's, and whether it
+// decompresses to NBT.
+func (r *regionFile) check() (chunks int, problems []chunkProblem) {
+	if len(r.data) < regionHeaderSize {
+		return 0, []chunkProblem{{Region: true, Problem: fmt.Sprintf("%v (%d bytes)", errRegionHeader, len(r.data))}}
+	}
+	owner := make(map[int]int)
+	for i := 0; i < regionChunks; i++ {
+		offset, sectors := r.location(i)
+		if sectors == 0 {
+			continue
+		}
+		chunks++
+		x, z := r.coords(i)
+		fail := func(err error) {
+			problems = append(problems, chunkProblem{X: x, Z: z, Problem: err.Error()})
+		}
+		overlap := -1
+		for s := offset; s < offset+sectors && offset >= 2; s++ {
+			if j, ok := owner[s]; ok && overlap < 0 {
+				overlap = j
+			}
+			owner[s] = i
+		}
+		if overlap >= 0 {
+			ox, oz := r.coords(overlap)
+			fail(fmt.Errorf("shares sectors with chunk %d,%d", ox, oz))
+			continue
+		}
+		if _, err := r.chunk(i); err != nil {
+			fail(err)
+		}
+	}
+	return chunks, problems
+}
+
+// checkRegions checks every region file of the worlds under savesDir, leaving out
+// the worlds in skip.
+func checkRegions(ctx context.Context, savesDir string, skip []string, progress func(done, total int64)) (*regionCheck, error) {
+	files, err := regionFiles(savesDir)
+	if err != nil {
+		return nil, err
+	}
+	skipped := make(map[string]bool, len(skip))
+	for _, s := range skip {
+		skipped[s] = true
+	}
+	var todo []string
+	for _, f := range files {
+		if !skipped[regionWorld(savesDir, f)] {
+			todo = append(todo, f)
+		}
+	}
+	var mu sync.Mutex
+	res := &regionCheck{}
+	err = eachRegion(ctx, todo, progress, func(path string) error {
+		r, err := readRegionFile(path)
+		if errors.Is(err, errRegionName) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		chunks, problems := r.check()
+		rel, _ := filepath.Rel(savesDir, path)
+		mu.Lock()
+		defer mu.Unlock()
+		res.Regions++
+		res.Chunks += chunks
+		for _, p := range problems {
+			p.File = filepath.ToSlash(rel)
+			res.Problems = append(res.Problems, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(res.Problems, func(a, b int) bool {
+		pa, pb := res.Problems[a], res.Problems[b]
+		if pa.File != pb.File {
+			return pa.File < pb.File
+		}
+		if pa.Z != pb.Z {
+			return pa.Z < pb.Z
+		}
+		return pa.X < pb.X
+	})
+	return res, nil
+}
+
+// newProblems are the problems of c that before did not have.
+func (c *regionCheck) newProblems(before *regionCheck) []chunkProblem {
+	known := make(map[string]bool)
+	if before != nil {
+		for _, p := range before.Problems {
+			known[p.key()] = true
+		}
+	}
+	var added []chunkProblem
+	for _, p := range c.Problems {
+		if !known[p.key()] {
+			added = append(added, p)
+		}
+	}
+	return added
+}
+
+func (c *regionCheck) describe() string {
+	return fmt.Sprintf("%d regions, %d chunks, %d damaged", c.Regions, c.Chunks, len(c.Problems))
+}
+
+// regionDamageError fails a migration whose copied worlds have damage the
+// originals did not have.
+type regionDamageError struct {
+	Problems []chunkProblem
+}
+
+func (e *regionDamageError) Error() string {
+	var lines []string
+	for i, p := range e.Problems {
+		if i == 5 {
+			lines = append(lines, fmt.Sprintf("and %d more", len(e.Problems)-i))
+			break
+		}
+		lines = append(lines, p.describe())
+	}
+	return "the copied worlds have damaged chunks the originals do not: " + strings.Join(lines, "; ")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const rangeBlockSize = 1 << 20
+
+// httpRangeReader is an io.ReaderAt over a remote file served with HTTP range
+// support. Reads are answered from 1 MiB blocks so the many small reads archive/zip
+// makes while walking the central directory turn into a handful of requests.
+type httpRangeReader struct {
+	url    string
+	size   int64
+	blocks map[int64][]byte
+}
+
+func newHTTPRangeReader(url string, size int64) *httpRangeReader {
+	return &httpRangeReader{url: url, size: size, blocks: make(map[int64][]byte)}
+}
+
+func (r *httpRangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && off+int64(n) < r.size {
+		pos := off + int64(n)
+		idx := pos / rangeBlockSize
+		block, err := r.block(idx)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block[pos-idx*rangeBlockSize:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *httpRangeReader) block(idx int64) ([]byte, error) {
+	if b, ok := r.blocks[idx]; ok {
+		return b, nil
+	}
+	start := idx * rangeBlockSize
+	end := start + rangeBlockSize - 1
+	if end >= r.size {
+		end = r.size - 1
+	}
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("range request not supported: %s", resp.Status)
+	}
+	want := end - start + 1
+	b, err := io.ReadAll(io.LimitReader(resp.Body, want))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) != want {
+		return nil, fmt.Errorf("short range response: got %d of %d bytes", len(b), want)
+	}
+	r.blocks[idx] = b
+	return b, nil
+}
+
+// openRemoteZip reads the central directory of a remote archive without
+// downloading the whole file.
+func openRemoteZip(url string, size int64) (*zip.Reader, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("archive size unknown")
+	}
+	return zip.NewReader(newHTTPRangeReader(url, size), size)
+}
+
+// zipLayout returns the set of slash-separated paths (files and their parent
+// directories) an archive produces once extracted and flattened the same way
+// maybeFlattenSingleDir does.
+func zipLayout(files []*zip.File) map[string]bool {
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		name := strings.Trim(path.Clean(strings.ReplaceAll(f.Name, "\\", "/")), "/")
+		if name == "" || name == "." || strings.HasPrefix(name, "..") {
+			continue
+		}
+		names = append(names, name)
+	}
+
+	// an archive whose only top-level entry is a directory gets flattened
+	top := ""
+	flatten := len(names) > 0
+	for _, name := range names {
+		first, _, nested := strings.Cut(name, "/")
+		if top == "" {
+			top = first
+		}
+		if first != top {
+			flatten = false
+			break
+		}
+		if !nested && !isZipDir(files, name) {
+			flatten = false
+			break
+		}
+	}
+
+	layout := make(map[string]bool)
+	for _, name := range names {
+		if flatten {
+			name = strings.TrimPrefix(strings.TrimPrefix(name, top), "/")
+			if name == "" {
+				continue
+			}
+		}
+		for p := name; p != "." && p != ""; p = path.Dir(p) {
+			layout[p] = true
+		}
+	}
+	return layout
+}
+
+func isZipDir(files []*zip.File, name string) bool {
+	for _, f := range files {
+		if strings.Trim(f.Name, "/") == name {
+			return f.FileInfo().IsDir()
+		}
+	}
+	return false
+}
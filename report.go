@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// migrationReport collects what the steps after the copy did. A short summary is
+// shown when the migration finishes and the full report is saved in the new instance.
+type migrationReport struct {
+	Destination  string
+	ConfigMerge  *configMergeResult
+	FileMerges   []fileMergeResult
+	Conflicts    []conflictOutcome
+	Mods         *modDiff
+	Settings     *instanceSettingsResult
+	Launcher     *launcherResult
+	Transfers    []entryTransfer
+	Verification *verifyResult
+	Upgrade      *upgradeResult
+	Backup       *backupResult
+	Worlds       []worldInfo
+	ChunkPrune   []*chunkScan
+	Regions      *regionChecks
+}
+
+type entryTransfer struct {
+	Path string       `json:"path"`
+	Mode transferMode `json:"mode"`
+	transferStats
+}
+
+func (r *migrationReport) summary() []string {
+	var lines []string
+	if b := r.Backup; b != nil {
+		if b.Format == backupFormatStore {
+			lines = append(lines, fmt.Sprintf("Backup: snapshot %s (%d files, %s new in the store)", b.Snapshot, b.Files, formatBytes(b.Size)))
+		} else {
+			lines = append(lines, fmt.Sprintf("Backup: %s (%d files, %s)", b.Archive, b.Files, formatBytes(b.Size)))
+		}
+		if len(b.Pruned) > 0 {
+			lines = append(lines, fmt.Sprintf("Removed %d older backups the retention policy does not keep", len(b.Pruned)))
+		}
+		if b.PruneError != "" {
+			lines = append(lines, "Removing older backups failed: "+b.PruneError)
+		}
+	}
+	if u := r.Upgrade; u != nil {
+		lines = append(lines, fmt.Sprintf("Replaced %s; undo with: gtnh-updater-cli rollback -instance %q", strings.Join(u.Replaced, ", "), r.Destination))
+		if len(u.Pruned) > 0 {
+			lines = append(lines, fmt.Sprintf("Removed %d older rollbacks the retention policy does not keep", len(u.Pruned)))
+		}
+		if u.PruneError != "" {
+			lines = append(lines, "Removing older rollbacks failed: "+u.PruneError)
+		}
+	}
+	var filtered int
+	var filteredBytes int64
+	for _, t := range r.Transfers {
+		filtered += t.Filtered
+		filteredBytes += t.FilteredBytes
+	}
+	if filtered > 0 {
+		lines = append(lines, fmt.Sprintf("Exclusion rules: left out %d files (%s)", filtered, formatBytes(filteredBytes)))
+	}
+	for _, t := range r.Transfers {
+		if t.Fallbacks > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %d of %d files could not %s and were copied (%s)", t.Path, t.Fallbacks, t.Files, t.Mode, t.Reason))
+		}
+		for _, n := range t.Notes {
+			lines = append(lines, fmt.Sprintf("%s: %s", t.Path, n))
+		}
+	}
+	for _, w := range r.Worlds {
+		if w.Newer && !w.Skip {
+			lines = append(lines, fmt.Sprintf("Warning: world %s was last played with GTNH %s, a newer version than this one", w.Folder, w.PackVersion))
+		}
+	}
+	if len(r.ChunkPrune) > 0 {
+		var unvisited, dropped int
+		var freed int64
+		for _, s := range r.ChunkPrune {
+			unvisited += s.Unvisited
+			dropped += s.Dropped
+			freed += s.DroppedBytes
+		}
+		lines = append(lines, fmt.Sprintf("Chunks: dropped %d of %d unvisited chunks from the new worlds (%s)", dropped, unvisited, formatBytes(freed)))
+	}
+	if rc := r.Regions; rc != nil && rc.Source != nil && len(rc.Source.Problems) > 0 {
+		lines = append(lines, fmt.Sprintf("Warning: %d damaged chunks in the original worlds, carried over as they were; the full report lists them", len(rc.Source.Problems)))
+	}
+	if v := r.Verification; v != nil {
+		line := fmt.Sprintf("Verified %d files (%s)", v.Files, formatBytes(v.Bytes))
+		if n := len(v.Mismatched) + len(v.Missing); n > 0 {
+			line += fmt.Sprintf(", %d outside the worlds differ or are missing", n)
+		}
+		lines = append(lines, line)
+	}
+	if cm := r.ConfigMerge; cm != nil {
+		kept, conflicts := cm.counts()
+		line := fmt.Sprintf("Configs: kept %d of your settings in %d files", kept, len(cm.Files))
+		if conflicts > 0 {
+			line += fmt.Sprintf(", %d conflicts kept the new default", conflicts)
+		}
+		if len(cm.NoBaseline) > 0 {
+			line += fmt.Sprintf(", %d files merged without the old pack's defaults (your values won)", len(cm.NoBaseline))
+		}
+		lines = append(lines, line)
+	}
+	if len(r.Conflicts) > 0 {
+		counts := make(map[conflictPolicy]int)
+		for _, c := range r.Conflicts {
+			counts[c.Policy]++
+		}
+		var parts []string
+		for _, p := range conflictPolicies {
+			if counts[p] > 0 {
+				parts = append(parts, fmt.Sprintf("%d %s", counts[p], p))
+			}
+		}
+		lines = append(lines, fmt.Sprintf("Conflicts: the new pack had %d of your files too (%s)", len(r.Conflicts), strings.Join(parts, ", ")))
+	}
+	for _, fm := range r.FileMerges {
+		line := fmt.Sprintf("%s: kept %d of your settings, %d new defaults", fm.Path, fm.Kept, fm.Added)
+		if len(fm.Extra) > 0 {
+			line += fmt.Sprintf(", %d keys the new pack lacks kept", len(fm.Extra))
+		}
+		if len(fm.Dropped) > 0 {
+			line += fmt.Sprintf(", %d keys of removed mods dropped", len(fm.Dropped))
+		}
+		lines = append(lines, line)
+	}
+	if s := r.Settings; s != nil {
+		lines = append(lines, fmt.Sprintf("Instance settings: %d launcher settings carried over, named %q", len(s.Copied), s.Name))
+		if len(s.LeftOut) > 0 {
+			lines = append(lines, fmt.Sprintf("Warning: your Java path and arguments were not carried over (%s); set them in the launcher if the defaults do not suit", s.JavaNote))
+		}
+	}
+	if l := r.Launcher; l != nil && (l.IconKey != "" || l.Group != "") {
+		line := "Launcher:"
+		if l.IconKey != "" {
+			line += " icon " + l.IconKey
+		}
+		if l.Group != "" {
+			line += fmt.Sprintf(" group %q", l.Group)
+		}
+		lines = append(lines, line)
+	}
+	if md := r.Mods; md != nil {
+		lines = append(lines, fmt.Sprintf("Mods: %d added, %d removed, %d updated", len(md.Added), len(md.Removed), len(md.Updated)))
+		if len(md.UserMods) > 0 {
+			lines = append(lines, fmt.Sprintf("Your own mods: %d found, %d copied", len(md.UserMods), len(md.Copied)))
+		}
+	}
+	return lines
+}
+
+func (r *migrationReport) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Migration report for %s\n", r.Destination)
+	if b := r.Backup; b != nil {
+		fmt.Fprintln(w, "\n== Backup ==")
+		if b.Format == backupFormatStore {
+			fmt.Fprintf(w, "snapshot %s in %s\n%d files, %s, %s new in the store\n", b.Snapshot, b.Archive, b.Files, formatBytes(b.Bytes), formatBytes(b.Size))
+		} else {
+			fmt.Fprintf(w, "%s\n%d files, %s, archive %s\n", b.Archive, b.Files, formatBytes(b.Bytes), formatBytes(b.Size))
+		}
+		for _, p := range b.Pruned {
+			fmt.Fprintf(w, "  removed   %s\n", p)
+		}
+		if b.PruneError != "" {
+			fmt.Fprintf(w, "removing older backups failed: %s\n", b.PruneError)
+		}
+	}
+	if len(r.Worlds) > 0 {
+		fmt.Fprintln(w, "\n== Worlds ==")
+		for _, wi := range r.Worlds {
+			if wi.Skip {
+				fmt.Fprintln(w, wi.describe()+"  (not migrated)")
+				continue
+			}
+			fmt.Fprintln(w, wi.describe())
+			if wi.Newer {
+				fmt.Fprintf(w, "  last played with GTNH %s, a newer version than this one\n", wi.PackVersion)
+			}
+		}
+	}
+	if len(r.ChunkPrune) > 0 {
+		fmt.Fprintln(w, "\n== Unvisited chunks ==")
+		for _, s := range r.ChunkPrune {
+			fmt.Fprintln(w, s.describe())
+		}
+	}
+	if rc := r.Regions; rc != nil {
+		fmt.Fprintln(w, "\n== Region files ==")
+		for _, c := range []struct {
+			name  string
+			check *regionCheck
+		}{{"original worlds", rc.Source}, {"copied worlds", rc.Destination}} {
+			if c.check == nil {
+				continue
+			}
+			fmt.Fprintf(w, "%s: %s\n", c.name, c.check.describe())
+			for _, p := range c.check.Problems {
+				fmt.Fprintf(w, "  %s\n", p.describe())
+			}
+		}
+	}
+	if u := r.Upgrade; u != nil {
+		fmt.Fprintln(w, "\n== Upgraded in place ==")
+		for _, p := range u.Replaced {
+			fmt.Fprintf(w, "  replaced  %s\n", p)
+		}
+		fmt.Fprintf(w, "previous pack folders kept in rollback %s (%s)\n", u.Rollback, filepath.Join(rollbackDir(r.Destination), u.Rollback))
+		for _, id := range u.Pruned {
+			fmt.Fprintf(w, "  removed   rollback %s\n", id)
+		}
+		if u.PruneError != "" {
+			fmt.Fprintf(w, "removing older rollbacks failed: %s\n", u.PruneError)
+		}
+	}
+	if len(r.Transfers) > 0 {
+		fmt.Fprintln(w, "\n== Transferred ==")
+		for _, t := range r.Transfers {
+			fmt.Fprintf(w, "  %-8s %-24s %d files", t.Mode, t.Path, t.Files)
+			if t.Fallbacks > 0 {
+				fmt.Fprintf(w, ", %d copied instead (%s)", t.Fallbacks, t.Reason)
+			}
+			if t.Filtered > 0 {
+				fmt.Fprintf(w, ", %d left out by exclusion rules (%s)", t.Filtered, formatBytes(t.FilteredBytes))
+			}
+			fmt.Fprintln(w)
+			for _, n := range t.Notes {
+				fmt.Fprintf(w, "           %s\n", n)
+			}
+		}
+	}
+	if v := r.Verification; v != nil {
+		fmt.Fprintln(w, "\n== Verification ==")
+		fmt.Fprintf(w, "%d files, %s checked", v.Files, formatBytes(v.Bytes))
+		if v.SizeOnly > 0 {
+			fmt.Fprintf(w, " (%d moved files by size only)", v.SizeOnly)
+		}
+		fmt.Fprintln(w)
+		for _, p := range v.Mismatched {
+			fmt.Fprintf(w, "  differs   %s (%s)\n", p.Path, p.Reason)
+		}
+		for _, p := range v.Missing {
+			fmt.Fprintf(w, "  missing   %s\n", p.Path)
+		}
+	}
+	if cm := r.ConfigMerge; cm != nil {
+		fmt.Fprintln(w, "\n== Config merge ==")
+		for _, f := range cm.Files {
+			fmt.Fprintf(w, "\n%s\n", f.Path)
+			for _, k := range f.Kept {
+				fmt.Fprintf(w, "  kept      %s\n", k)
+			}
+			for _, c := range f.Conflicts {
+				fmt.Fprintf(w, "  conflict  %s\n", c.Key)
+				fmt.Fprintf(w, "            old default: %s\n", oneLine(c.Base))
+				fmt.Fprintf(w, "            yours:       %s\n", oneLine(c.User))
+				fmt.Fprintf(w, "            new default: %s (used)\n", oneLine(c.New))
+			}
+			for _, k := range f.Dropped {
+				fmt.Fprintf(w, "  dropped   %s (no longer in the pack)\n", k)
+			}
+		}
+		if len(cm.NoBaseline) > 0 {
+			fmt.Fprintln(w, "\nMerged without the old pack's defaults; your values won, so check these for defaults the new pack meant to change:")
+			for _, p := range cm.NoBaseline {
+				fmt.Fprintf(w, "  %s\n", p)
+			}
+		}
+	}
+	if len(r.Conflicts) > 0 {
+		fmt.Fprintln(w, "\n== Conflicts ==")
+		for _, c := range r.Conflicts {
+			line := fmt.Sprintf("  %-10s %s", c.Policy, c.Path)
+			if c.KeptAs != "" {
+				line += "  (yours is " + c.KeptAs + ")"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+	for _, fm := range r.FileMerges {
+		fmt.Fprintf(w, "\n== %s ==\n", fm.Path)
+		fmt.Fprintf(w, "kept %d of your settings, took %d new defaults\n", fm.Kept, fm.Added)
+		for _, k := range fm.Extra {
+			fmt.Fprintf(w, "  kept      %s (not in the new pack's file)\n", k)
+		}
+		for _, k := range fm.Dropped {
+			fmt.Fprintf(w, "  dropped   %s (mod %s is no longer in the pack)\n", k, optionMod(k))
+		}
+	}
+	if s := r.Settings; s != nil {
+		fmt.Fprintln(w, "\n== Instance settings ==")
+		fmt.Fprintf(w, "name set to %q\n", s.Name)
+		for _, k := range s.Copied {
+			fmt.Fprintf(w, "  copied    %s\n", k)
+		}
+		for _, k := range s.LeftOut {
+			fmt.Fprintf(w, "  left out  %s (%s)\n", k, s.JavaNote)
+		}
+	}
+	if l := r.Launcher; l != nil {
+		fmt.Fprintln(w, "\n== Launcher ==")
+		if l.IconKey != "" {
+			fmt.Fprintf(w, "icon key %s\n", l.IconKey)
+		}
+		if l.IconFile != "" {
+			fmt.Fprintf(w, "icon file %s copied\n", l.IconFile)
+		}
+		if l.Group != "" {
+			fmt.Fprintf(w, "added to group %q (close the launcher first, or it may rewrite the groups on exit)\n", l.Group)
+		}
+	}
+	if md := r.Mods; md != nil {
+		fmt.Fprintln(w, "\n== Mods ==")
+		for _, m := range md.Added {
+			fmt.Fprintf(w, "  added     %s\n", m.File)
+		}
+		for _, m := range md.Removed {
+			fmt.Fprintf(w, "  removed   %s\n", m.File)
+		}
+		for _, u := range md.Updated {
+			fmt.Fprintf(w, "  updated   %s (%s)\n", u.ID, u.Changed)
+		}
+		if len(md.UserMods) > 0 {
+			copied := make(map[string]bool, len(md.Copied))
+			for _, f := range md.Copied {
+				copied[f] = true
+			}
+			fmt.Fprintln(w, "\nYour own mods:")
+			for _, um := range md.UserMods {
+				state := "not copied"
+				if copied[um.Jar.File] {
+					state = "copied"
+				}
+				line := fmt.Sprintf("  %-10s %s", state, um.Jar.File)
+				if um.Warning != "" {
+					line += " (" + um.Warning + ")"
+				}
+				if !um.Verified {
+					line += " (may be a mod the pack dropped)"
+				}
+				fmt.Fprintln(w, line)
+			}
+		}
+	}
+}
+
+func migrationReportFile(instancePath string) string {
+	return filepath.Join(updaterDir(instancePath), "migration-report.txt")
+}
+
+// save writes the full report into the instance's updater folder and returns its path.
+func (r *migrationReport) save(instancePath string) (string, error) {
+	path := migrationReportFile(instancePath)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	r.writeText(f)
+	return path, nil
+}
+
+func oneLine(s string) string {
+	return strings.ReplaceAll(s, "\n", ", ")
+}
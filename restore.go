@@ -0,0 +1,315 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// availableBackup is one world backup of either format, as the restore screen and
+// command list it.
+type availableBackup struct {
+	ID     string `json:"id"`
+	Format string `json:"format"`
+	// Path is the zip, or the store folder for a snapshot.
+	Path     string    `json:"path"`
+	Instance string    `json:"instance"`
+	Created  time.Time `json:"created"`
+	Entries  []string  `json:"entries"`
+	Worlds   []string  `json:"worlds,omitempty"`
+	Files    int       `json:"files"`
+	Bytes    int64     `json:"bytes"`
+}
+
+// listAvailableBackups finds the zips and store snapshots in dir, newest first.
+func listAvailableBackups(dir string) ([]availableBackup, error) {
+	var backups []availableBackup
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".zip")
+		if e.IsDir() || !ok {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		m, err := readBackupManifest(path)
+		if err != nil {
+			// not one of ours
+			continue
+		}
+		backups = append(backups, availableBackup{ID: name, Format: backupFormatZip, Path: path, Instance: m.Instance, Created: m.Created,
+			Entries: m.Entries, Worlds: m.Worlds, Files: m.Files, Bytes: m.Bytes})
+	}
+	if storeDir := backupStoreDir(dir); pathExists(storeDir) {
+		store := &backupStore{dir: storeDir}
+		snaps, err := store.snapshots()
+		if err != nil {
+			return nil, err
+		}
+		for _, snap := range snaps {
+			backups = append(backups, availableBackup{ID: snap.ID, Format: backupFormatStore, Path: storeDir, Instance: snap.Instance, Created: snap.Created,
+				Entries: snap.Entries, Worlds: snap.Worlds, Files: len(snap.Files), Bytes: snap.Bytes})
+		}
+	}
+	sort.SliceStable(backups, func(a, b int) bool { return backups[a].Created.After(backups[b].Created) })
+	return backups, nil
+}
+
+func (b availableBackup) describe() string {
+	line := fmt.Sprintf("%s  %s  from %s  %d files, %s", b.ID, b.Created.Format("2006-01-02 15:04"), filepath.Base(b.Instance), b.Files, formatBytes(b.Bytes))
+	if len(b.Worlds) > 0 {
+		line += "  worlds: " + strings.Join(b.Worlds, ", ")
+	}
+	return line
+}
+
+// restoreUnits are the folders a restore replaces as a whole: each world of the
+// backup, or just world when it is set, and every other backed-up entry.
+func (b availableBackup) restoreUnits(world string) ([]string, error) {
+	if world != "" {
+		for _, w := range b.Worlds {
+			if w == world {
+				return []string{"saves/" + w}, nil
+			}
+		}
+		return nil, fmt.Errorf("backup %s has no world %q", b.ID, world)
+	}
+	var units []string
+	for _, e := range b.Entries {
+		if e != "saves" {
+			units = append(units, e)
+			continue
+		}
+		for _, w := range b.Worlds {
+			units = append(units, "saves/"+w)
+		}
+	}
+	return units, nil
+}
+
+// newerDataError refuses a restore that would overwrite data changed after the
+// backup was taken.
+type newerDataError struct {
+	Backup time.Time
+	Paths  []string
+}
+
+func (e *newerDataError) Error() string {
+	return fmt.Sprintf("%s changed after the backup was taken (%s); force the restore to overwrite it", strings.Join(e.Paths, ", "), e.Backup.Format("2006-01-02 15:04"))
+}
+
+type restoreResult struct {
+	Backup   string   `json:"backup"`
+	Target   string   `json:"target"`
+	Restored []string `json:"restored"`
+}
+
+// restoreBackup puts the backup, or one world of it, into the target instance.
+// Everything is unpacked inside the instance first and only then swapped in, and
+// folders being replaced are put back if a swap fails.
+func restoreBackup(ctx context.Context, b availableBackup, world, target string, force bool) (*restoreResult, error) {
+	if !pathExists(target) {
+		return nil, fmt.Errorf("instance not found: %s", target)
+	}
+	units, err := b.restoreUnits(world)
+	if err != nil {
+		return nil, err
+	}
+	if len(units) == 0 {
+		return nil, fmt.Errorf("backup %s is empty", b.ID)
+	}
+	root := destinationRoot(target, pathExists)
+	if !force {
+		newer := &newerDataError{Backup: b.Created}
+		for _, u := range units {
+			changed, err := newestChange(filepath.Join(root, filepath.FromSlash(u)))
+			if err != nil {
+				return nil, err
+			}
+			if changed.After(b.Created) {
+				newer.Paths = append(newer.Paths, u)
+			}
+		}
+		if len(newer.Paths) > 0 {
+			return nil, newer
+		}
+	}
+
+	staging := filepath.Join(updaterDir(target), "restore-staging")
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, err
+	}
+	// leave no updater folder behind in an instance that did not have one
+	defer os.Remove(updaterDir(target))
+	defer os.RemoveAll(staging)
+	unpacked, replaced := filepath.Join(staging, "backup"), filepath.Join(staging, "replaced")
+	if err := unpackBackup(ctx, b, units, unpacked); err != nil {
+		return nil, fmt.Errorf("unpack %s: %w", b.ID, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var moved []string
+	putBack := func() {
+		for _, u := range moved {
+			dst := filepath.Join(root, filepath.FromSlash(u))
+			_ = os.RemoveAll(dst)
+			_ = os.Rename(filepath.Join(replaced, filepath.FromSlash(u)), dst)
+		}
+	}
+	res := &restoreResult{Backup: b.ID, Target: target}
+	for _, u := range units {
+		dst := filepath.Join(root, filepath.FromSlash(u))
+		if pathExists(dst) {
+			old := filepath.Join(replaced, filepath.FromSlash(u))
+			if err := os.MkdirAll(filepath.Dir(old), 0o755); err != nil {
+				putBack()
+				return nil, err
+			}
+			if err := os.Rename(dst, old); err != nil {
+				putBack()
+				return nil, fmt.Errorf("move %s aside: %w", u, err)
+			}
+		}
+		moved = append(moved, u)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			putBack()
+			return nil, err
+		}
+		if err := os.Rename(filepath.Join(unpacked, filepath.FromSlash(u)), dst); err != nil {
+			putBack()
+			return nil, fmt.Errorf("restore %s: %w", u, err)
+		}
+		res.Restored = append(res.Restored, u)
+	}
+	return res, nil
+}
+
+// newestChange is the latest modification time of the files under path, or the
+// zero time when there are none. Folders do not count: a restore creates them anew.
+func newestChange(path string) (time.Time, error) {
+	var newest time.Time
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	return newest, err
+}
+
+// unpackBackup writes the units of the backup under dir, laid out like the game folder.
+func unpackBackup(ctx context.Context, b availableBackup, units []string, dir string) error {
+	if b.Format == backupFormatStore {
+		store := &backupStore{dir: b.Path}
+		snap, err := store.snapshot(b.ID)
+		if err != nil {
+			return err
+		}
+		for _, u := range units {
+			if err := store.restore(ctx, snap, u, dir, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := unpackBackupZip(ctx, b.Path, units, dir); err != nil {
+		return err
+	}
+	// zips have no entries for folders, so an empty world has to be made by hand
+	for _, u := range units {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.FromSlash(u)), 0o755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unpackBackupZip(ctx context.Context, archive string, units []string, dir string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		wanted := false
+		for _, u := range units {
+			wanted = wanted || strings.HasPrefix(f.Name, u+"/")
+		}
+		if !wanted || strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if !strings.HasPrefix(dst, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("illegal file path in zip: %s", f.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := unpackZipFile(f, dst); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+func unpackZipFile(f *zip.File, dst string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, f.Mode().Perm()|0o200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, f.Modified, f.Modified)
+}
+
+// findBackup picks the backup id, or the newest backup taken from instance when
+// id is empty.
+func findBackup(backups []availableBackup, id, instance string) (availableBackup, error) {
+	for _, b := range backups {
+		if (id != "" && b.ID == id) || (id == "" && b.Instance == instance) {
+			return b, nil
+		}
+	}
+	if id != "" {
+		return availableBackup{}, errors.New("backup not found: " + id)
+	}
+	return availableBackup{}, fmt.Errorf("no backups taken from %s; pick one by id", instance)
+}
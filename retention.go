@@ -0,0 +1,254 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retentionPolicy decides which backups to keep. A backup is kept when any rule
+// keeps it; MaxTotalSize then drops the oldest kept ones until the rest fit. The
+// newest backup is always kept. A policy with no rules set keeps everything.
+type retentionPolicy struct {
+	KeepLast    int `json:"keepLast,omitempty"`
+	KeepDaily   int `json:"keepDaily,omitempty"`
+	KeepWeekly  int `json:"keepWeekly,omitempty"`
+	KeepMonthly int `json:"keepMonthly,omitempty"`
+	// MaxTotalSize is a size such as "50 GiB" or "500MB".
+	MaxTotalSize string `json:"maxTotalSize,omitempty"`
+}
+
+func (p *retentionPolicy) active() bool {
+	return p != nil && (p.KeepLast > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0 || p.MaxTotalSize != "")
+}
+
+// backupItem is one backup a policy applies to: an archive made by the updater or
+// a file in the game's own backups folder.
+type backupItem struct {
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// apply splits items into the ones the policy keeps and the ones it drops.
+func (p *retentionPolicy) apply(items []backupItem) (keep, drop []backupItem, err error) {
+	if !p.active() || len(items) == 0 {
+		return items, nil, nil
+	}
+	maxSize := int64(-1)
+	if p.MaxTotalSize != "" {
+		if maxSize, err = parseByteSize(p.MaxTotalSize); err != nil {
+			return nil, nil, fmt.Errorf("maxTotalSize: %w", err)
+		}
+	}
+	sorted := append([]backupItem{}, items...)
+	// backups made at the same moment keep the order they were listed in
+	sort.SliceStable(sorted, func(a, b int) bool { return sorted[a].Time.After(sorted[b].Time) })
+
+	kept := make([]bool, len(sorted))
+	kept[0] = true
+	onlySize := p.KeepLast == 0 && p.KeepDaily == 0 && p.KeepWeekly == 0 && p.KeepMonthly == 0
+	for i := range sorted {
+		kept[i] = kept[i] || onlySize || i < p.KeepLast
+	}
+	periods := []struct {
+		n   int
+		key func(time.Time) string
+	}{
+		{p.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.KeepWeekly, func(t time.Time) string { y, w := t.ISOWeek(); return fmt.Sprintf("%d-%02d", y, w) }},
+		{p.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, period := range periods {
+		seen := make(map[string]bool)
+		for i, it := range sorted {
+			if len(seen) == period.n {
+				break
+			}
+			k := period.key(it.Time.Local())
+			if !seen[k] {
+				seen[k] = true
+				kept[i] = true
+			}
+		}
+	}
+	if maxSize >= 0 {
+		var total int64
+		full := false
+		for i, it := range sorted {
+			if !kept[i] {
+				continue
+			}
+			// once one does not fit, it and everything older goes, so an old
+			// small backup never outlives a newer large one
+			if full || i > 0 && total+it.Size > maxSize {
+				kept[i], full = false, true
+				continue
+			}
+			total += it.Size
+		}
+	}
+	for i, it := range sorted {
+		if kept[i] {
+			keep = append(keep, it)
+		} else {
+			drop = append(drop, it)
+		}
+	}
+	return keep, drop, nil
+}
+
+// parseByteSize reads sizes like "512", "500MB", "50 GiB". Units are powers of 1024.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	num := strings.TrimRight(s, "KMGTIB ")
+	unit := strings.TrimSpace(s[len(num):])
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	mult := map[string]float64{"": 1, "B": 1, "K": 1 << 10, "KB": 1 << 10, "KIB": 1 << 10, "M": 1 << 20, "MB": 1 << 20, "MIB": 1 << 20,
+		"G": 1 << 30, "GB": 1 << 30, "GIB": 1 << 30, "T": 1 << 40, "TB": 1 << 40, "TIB": 1 << 40}
+	m, ok := mult[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit %q", unit)
+	}
+	return int64(n * m), nil
+}
+
+// listBackupArchives finds the updater's archives in dir, grouped by the instance
+// they were taken from.
+func listBackupArchives(dir string) (map[string][]backupItem, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string][]backupItem)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".zip") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		m, err := readBackupManifest(path)
+		if err != nil {
+			// not one of ours
+			continue
+		}
+		groups[m.Instance] = append(groups[m.Instance], backupItem{Path: path, Time: m.Created, Size: info.Size()})
+	}
+	return groups, nil
+}
+
+func readBackupManifest(archive string) (*backupManifest, error) {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.Name != backupManifestName {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, 1<<20))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		m := &backupManifest{}
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("%s has no %s", archive, backupManifestName)
+}
+
+// listInGameBackups finds the files in a game's backups folder, grouped by the
+// folder they are in, so per-world subfolders are rotated separately.
+func listInGameBackups(dir string) (map[string][]backupItem, error) {
+	groups := make(map[string][]backupItem)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		groups[filepath.Dir(p)] = append(groups[filepath.Dir(p)], backupItem{Path: p, Time: info.ModTime(), Size: info.Size()})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return groups, err
+}
+
+// retainedSkips lists, relative to dir, the in-game backups the policy would
+// drop, so a migration can leave them behind.
+func retainedSkips(dir string, p *retentionPolicy) (skip []string, bytes int64, err error) {
+	if !p.active() {
+		return nil, 0, nil
+	}
+	groups, err := listInGameBackups(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, items := range groups {
+		_, drop, err := p.apply(items)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, it := range drop {
+			rel, err := filepath.Rel(dir, it.Path)
+			if err != nil {
+				return nil, 0, err
+			}
+			skip = append(skip, filepath.ToSlash(rel))
+			bytes += it.Size
+		}
+	}
+	sort.Strings(skip)
+	return skip, bytes, nil
+}
+
+// pruneBackups applies the policy to every group and deletes what it drops,
+// unless dryRun is set. It returns the dropped backups.
+func pruneBackups(groups map[string][]backupItem, p *retentionPolicy, dryRun bool) ([]backupItem, error) {
+	var dropped []backupItem
+	for _, items := range groups {
+		_, drop, err := p.apply(items)
+		if err != nil {
+			return dropped, err
+		}
+		for _, it := range drop {
+			if !dryRun {
+				if err := os.Remove(it.Path); err != nil {
+					return dropped, err
+				}
+			}
+			dropped = append(dropped, it)
+		}
+	}
+	sort.Slice(dropped, func(a, b int) bool { return dropped[a].Path < dropped[b].Path })
+	return dropped, nil
+}
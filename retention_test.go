@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetentionApply(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.Local)
+	day := 24 * time.Hour
+	// item is a 10 byte backup named after its age
+	item := func(name string, age time.Duration) backupItem {
+		return backupItem{Path: name, Time: now.Add(-age), Size: 10}
+	}
+	tests := []struct {
+		name     string
+		policy   *retentionPolicy
+		items    []backupItem
+		keep     []string
+		drop     []string
+		parseErr bool
+	}{
+		{
+			name:   "no rules keep everything",
+			policy: &retentionPolicy{},
+			items:  []backupItem{item("old", 3*day), item("new", 0)},
+			keep:   []string{"old", "new"},
+		},
+		{
+			name:   "keep last",
+			policy: &retentionPolicy{KeepLast: 2},
+			items:  []backupItem{item("c", 2*day), item("a", 0), item("d", 3*day), item("b", day)},
+			keep:   []string{"a", "b"},
+			drop:   []string{"c", "d"},
+		},
+		{
+			name:   "ties keep the listed order",
+			policy: &retentionPolicy{KeepLast: 1},
+			items:  []backupItem{item("first", time.Hour), item("second", time.Hour)},
+			keep:   []string{"first"},
+			drop:   []string{"second"},
+		},
+		{
+			name:   "more to keep than there are",
+			policy: &retentionPolicy{KeepLast: 5},
+			items:  []backupItem{item("a", 0), item("b", day)},
+			keep:   []string{"a", "b"},
+		},
+		{
+			name:   "one per day",
+			policy: &retentionPolicy{KeepDaily: 2},
+			items:  []backupItem{item("today", 0), item("this morning", 4*time.Hour), item("yesterday", day), item("two days ago", 2*day)},
+			keep:   []string{"today", "yesterday"},
+			drop:   []string{"this morning", "two days ago"},
+		},
+		{
+			name:   "one per month",
+			policy: &retentionPolicy{KeepMonthly: 2},
+			items:  []backupItem{item("mar 15", 0), item("mar 1", 14*day), item("feb", 30*day), item("jan", 60*day)},
+			keep:   []string{"mar 15", "feb"},
+			drop:   []string{"mar 1", "jan"},
+		},
+		{
+			name:   "rules add up",
+			policy: &retentionPolicy{KeepLast: 1, KeepWeekly: 2},
+			items:  []backupItem{item("now", 0), item("hour", time.Hour), item("last week", 7*day), item("older", 14*day)},
+			keep:   []string{"now", "last week"},
+			drop:   []string{"hour", "older"},
+		},
+		{
+			name:   "size limit drops the oldest",
+			policy: &retentionPolicy{MaxTotalSize: "25"},
+			items:  []backupItem{item("a", 0), item("b", day), item("c", 2*day)},
+			keep:   []string{"a", "b"},
+			drop:   []string{"c"},
+		},
+		{
+			name:   "size limit stops at the first that does not fit",
+			policy: &retentionPolicy{MaxTotalSize: "60"},
+			items:  []backupItem{{Path: "new", Time: now, Size: 40}, {Path: "mid", Time: now.Add(-day), Size: 70}, {Path: "old", Time: now.Add(-2 * day), Size: 10}},
+			keep:   []string{"new"},
+			drop:   []string{"mid", "old"},
+		},
+		{
+			name:   "zero size still keeps the newest",
+			policy: &retentionPolicy{KeepLast: 3, MaxTotalSize: "0"},
+			items:  []backupItem{item("a", 0), item("b", day)},
+			keep:   []string{"a"},
+			drop:   []string{"b"},
+		},
+		{
+			name:     "bad size",
+			policy:   &retentionPolicy{MaxTotalSize: "lots"},
+			items:    []backupItem{item("a", 0)},
+			parseErr: true,
+		},
+	}
+	names := func(items []backupItem) []string {
+		var out []string
+		for _, it := range items {
+			out = append(out, it.Path)
+		}
+		return out
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, drop, err := tt.policy.apply(tt.items)
+			if (err != nil) != tt.parseErr {
+				t.Fatalf("err = %v, want error %v", err, tt.parseErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(names(keep), tt.keep) || !reflect.DeepEqual(names(drop), tt.drop) {
+				t.Errorf("keep %v, drop %v; want %v, %v", names(keep), names(drop), tt.keep, tt.drop)
+			}
+		})
+	}
+}
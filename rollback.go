@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rollbackDir holds one folder per in-place upgrade with everything the upgrade
+// replaced and a journal of its changes.
+func rollbackDir(instancePath string) string {
+	return filepath.Join(updaterDir(instancePath), "rollback")
+}
+
+const rollbackJournalFile = "journal.json"
+
+// Journal operations. Paths are relative to the instance folder.
+const (
+	// opBackup moved the path from the instance into the rollback folder.
+	opBackup = "backup"
+	// opInstall put the path into the instance; undoing it deletes the path.
+	opInstall = "install"
+)
+
+type journalChange struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+}
+
+// rollbackJournal records an in-place upgrade. Every change is written to the
+// journal before it is made, so even an upgrade that was interrupted halfway can
+// be rolled back.
+type rollbackJournal struct {
+	ID       string          `json:"id"`
+	Created  time.Time       `json:"created"`
+	Release  string          `json:"release"`
+	Complete bool            `json:"complete"`
+	Changes  []journalChange `json:"changes"`
+
+	instance string
+	dir      string
+}
+
+func newRollbackJournal(instance, release string) (*rollbackJournal, error) {
+	now := time.Now()
+	root := rollbackDir(instance)
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	id := now.Format("20060102-150405")
+	for n := 2; ; n++ {
+		err := os.Mkdir(filepath.Join(root, id), 0o755)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		id = fmt.Sprintf("%s-%d", now.Format("20060102-150405"), n)
+	}
+	j := &rollbackJournal{ID: id, Created: now, Release: release, instance: instance, dir: filepath.Join(root, id)}
+	return j, j.save()
+}
+
+func readRollbackJournal(instance, id string) (*rollbackJournal, error) {
+	dir := filepath.Join(rollbackDir(instance), id)
+	data, err := os.ReadFile(filepath.Join(dir, rollbackJournalFile))
+	if err != nil {
+		return nil, err
+	}
+	j := &rollbackJournal{}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("read rollback %s: %w", id, err)
+	}
+	j.ID, j.instance, j.dir = id, instance, dir
+	return j, nil
+}
+
+// listRollbacks returns the instance's rollbacks, newest first.
+func listRollbacks(instance string) ([]*rollbackJournal, error) {
+	entries, err := os.ReadDir(rollbackDir(instance))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var journals []*rollbackJournal
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		j, err := readRollbackJournal(instance, e.Name())
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		journals = append(journals, j)
+	}
+	sort.Slice(journals, func(a, b int) bool { return journals[a].Created.After(journals[b].Created) })
+	return journals, nil
+}
+
+func (j *rollbackJournal) save() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(j.dir, rollbackJournalFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// stored is where the rollback keeps the instance path rel.
+func (j *rollbackJournal) stored(rel string) string {
+	return filepath.Join(j.dir, rel)
+}
+
+// relPath turns a path inside the instance into a journal path.
+func (j *rollbackJournal) relPath(p string) (string, error) {
+	rel, err := filepath.Rel(j.instance, p)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s is not inside %s", p, j.instance)
+	}
+	return rel, nil
+}
+
+func (j *rollbackJournal) record(op, p string) error {
+	rel, err := j.relPath(p)
+	if err != nil {
+		return err
+	}
+	j.Changes = append(j.Changes, journalChange{Op: op, Path: filepath.ToSlash(rel)})
+	return j.save()
+}
+
+// backup moves p out of the instance into the rollback folder.
+func (j *rollbackJournal) backup(ctx context.Context, p string) error {
+	if err := j.record(opBackup, p); err != nil {
+		return err
+	}
+	rel, _ := j.relPath(p)
+	_, err := moveTree(ctx, p, j.stored(rel), nil)
+	return err
+}
+
+// install moves from into the instance at p.
+func (j *rollbackJournal) install(ctx context.Context, from, p string) error {
+	if err := j.record(opInstall, p); err != nil {
+		return err
+	}
+	_, err := moveTree(ctx, from, p, nil)
+	return err
+}
+
+// undo reverses the journal's changes, newest first, and deletes the rollback.
+func (j *rollbackJournal) undo() error {
+	for i := len(j.Changes) - 1; i >= 0; i-- {
+		c := j.Changes[i]
+		target := filepath.Join(j.instance, filepath.FromSlash(c.Path))
+		switch c.Op {
+		case opInstall:
+			if err := os.RemoveAll(target); err != nil {
+				return fmt.Errorf("remove %s: %w", c.Path, err)
+			}
+		case opBackup:
+			stored := j.stored(filepath.FromSlash(c.Path))
+			if !pathExists(stored) {
+				// recorded but the upgrade stopped before moving it
+				continue
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return fmt.Errorf("remove %s: %w", c.Path, err)
+			}
+			if _, err := moveTree(context.Background(), stored, target, nil); err != nil {
+				return fmt.Errorf("restore %s: %w", c.Path, err)
+			}
+		default:
+			return fmt.Errorf("rollback %s: unknown change %q", j.ID, c.Op)
+		}
+		j.Changes = j.Changes[:i]
+		if err := j.save(); err != nil {
+			return err
+		}
+	}
+	return j.discard()
+}
+
+// discard deletes the rollback folder, keeping the instance as it is.
+func (j *rollbackJournal) discard() error {
+	if err := os.RemoveAll(j.dir); err != nil {
+		return err
+	}
+	_ = os.Remove(rollbackDir(j.instance))
+	return nil
+}
+
+// defaultRollbacksKept is how many rollbacks an instance keeps when no retention
+// policy is set.
+const defaultRollbacksKept = 3
+
+// rollbackRetention is the policy rollbacks are pruned with: the configured one,
+// or keeping the last few.
+func rollbackRetention(p *retentionPolicy) *retentionPolicy {
+	if p.active() {
+		return p
+	}
+	return &retentionPolicy{KeepLast: defaultRollbacksKept}
+}
+
+// pruneRollbacks applies p to the instance's rollbacks and deletes the ones it
+// drops, unless dryRun is set. Rolling back to an upgrade undoes the newer ones
+// first, so once a rollback goes every older one goes too. Interrupted rollbacks
+// stay until they are rolled back.
+func pruneRollbacks(instance string, p *retentionPolicy, dryRun bool) ([]backupItem, error) {
+	journals, err := listRollbacks(instance)
+	if err != nil || len(journals) == 0 {
+		return nil, err
+	}
+	items := make([]backupItem, len(journals))
+	for i, j := range journals {
+		_, bytes, err := dirStats(j.dir)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = backupItem{Path: j.dir, Time: j.Created, Size: bytes}
+	}
+	_, drop, err := p.apply(items)
+	if err != nil || len(drop) == 0 {
+		return nil, err
+	}
+	dropped := make(map[string]bool, len(drop))
+	for _, it := range drop {
+		dropped[it.Path] = true
+	}
+	var removed []backupItem
+	cut := false
+	for i, j := range journals {
+		cut = cut || dropped[j.dir]
+		if !cut || !j.Complete {
+			continue
+		}
+		if !dryRun {
+			if err := j.discard(); err != nil {
+				return removed, err
+			}
+		}
+		removed = append(removed, items[i])
+	}
+	return removed, nil
+}
+
+// rollbackInstance undoes the in-place upgrade id of instance, or the newest one
+// when id is empty. Upgrades made after id are undone first so the instance ends
+// up exactly as it was before id. It returns the rollbacks applied, newest first.
+func rollbackInstance(instance, id string) ([]*rollbackJournal, error) {
+	journals, err := listRollbacks(instance)
+	if err != nil {
+		return nil, err
+	}
+	if len(journals) == 0 {
+		return nil, fmt.Errorf("no rollbacks available for %s", instance)
+	}
+	if id == "" {
+		id = journals[0].ID
+	}
+	found := false
+	for _, j := range journals {
+		found = found || j.ID == id
+	}
+	if !found {
+		return nil, errors.New("rollback not found: " + id)
+	}
+	var undone []*rollbackJournal
+	for _, j := range journals {
+		if err := j.undo(); err != nil {
+			return undone, fmt.Errorf("roll back %s: %w", j.ID, err)
+		}
+		undone = append(undone, j)
+		if j.ID == id {
+			return undone, nil
+		}
+	}
+	return undone, nil
+}
+
+func (j *rollbackJournal) describe() string {
+	var replaced []string
+	for _, c := range j.Changes {
+		if c.Op == opInstall && !strings.HasPrefix(c.Path, ".gtnh-updater/") {
+			replaced = append(replaced, c.Path)
+		}
+	}
+	line := fmt.Sprintf("%s  %s  %s", j.ID, j.Created.Format("2006-01-02 15:04"), j.Release)
+	if len(replaced) > 0 {
+		line += "  replaced " + strings.Join(replaced, ", ")
+	}
+	if !j.Complete {
+		line += "  (interrupted)"
+	}
+	return line
+}
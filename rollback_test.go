@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRollbackAfterUpgrades(t *testing.T) {
+	instance := filepath.Join(t.TempDir(), "GTNH")
+	writeTree(t, instance, oldInstance)
+	upgrade := func(version string) (id string, files map[string]string) {
+		t.Helper()
+		release := serveRelease(t, map[string]string{
+			"GTNH/mmc-pack.json":                            `{"components": ["` + version + `"]}`,
+			"GTNH/.minecraft/mods/core-" + version + ".jar": "core " + version,
+			"GTNH/.minecraft/config/core.cfg":               "version=" + version,
+		})
+		report, err := executeUpgrade(context.Background(), upgradeTestPlan(t, instance, release), func(string, int64, int64) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report.Upgrade.Rollback, readTree(t, instance)
+	}
+	before := readTree(t, instance)
+	first, afterFirst := upgrade("2.8")
+	second, _ := upgrade("2.9")
+	if first == second {
+		t.Fatalf("both upgrades got rollback %s", first)
+	}
+
+	tests := []struct {
+		id       string
+		want     map[string]string
+		wantUndo []string
+	}{
+		// the newest by default, then the first, which has nothing newer left
+		{"", afterFirst, []string{second}},
+		{first, before, []string{first}},
+	}
+	for _, tt := range tests {
+		undone, err := rollbackInstance(instance, tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, j := range undone {
+			ids = append(ids, j.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.wantUndo) {
+			t.Errorf("rollback %q undid %v, want %v", tt.id, ids, tt.wantUndo)
+		}
+		if got := readTree(t, instance); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("after rollback %q the instance holds %v, want %v", tt.id, got, tt.want)
+		}
+	}
+	if _, err := rollbackInstance(instance, ""); err == nil {
+		t.Error("rolled back with no rollbacks left")
+	}
+}
+
+func TestPruneRollbacks(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *retentionPolicy
+		// complete says which rollbacks finished, newest first
+		complete []bool
+		dryRun   bool
+		want     []int
+	}{
+		{"keeps the last three by default", rollbackRetention(&retentionPolicy{}), []bool{true, true, true, true, true}, false, []int{3, 4}},
+		{"keeps interrupted ones", &retentionPolicy{KeepLast: 1}, []bool{true, false, true, false}, false, []int{2}},
+		// the middle one is too big, so it and everything older go
+		{"drops everything older than the first dropped", &retentionPolicy{MaxTotalSize: "1KiB"}, []bool{true, true, true}, false, []int{1, 2}},
+		{"dry run", &retentionPolicy{KeepLast: 1}, []bool{true, true}, true, []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := t.TempDir()
+			now := time.Now()
+			var ids []string
+			for i, complete := range tt.complete {
+				j, err := newRollbackJournal(instance, "GTNH_2.8.0_Java_17-21.zip")
+				if err != nil {
+					t.Fatal(err)
+				}
+				j.Created, j.Complete = now.Add(-time.Duration(i)*time.Hour), complete
+				if err := j.save(); err != nil {
+					t.Fatal(err)
+				}
+				if i == 1 {
+					writeTree(t, j.dir, map[string]string{"mods/big.jar": string(make([]byte, 2048))})
+				}
+				ids = append(ids, j.ID)
+			}
+
+			removed, err := pruneRollbacks(instance, tt.policy, tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want []string
+			for _, it := range removed {
+				got = append(got, filepath.Base(it.Path))
+			}
+			for _, i := range tt.want {
+				want = append(want, ids[i])
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("removed %v, want %v", got, want)
+			}
+			left, err := listRollbacks(instance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantLeft := len(ids) - len(want)
+			if tt.dryRun {
+				wantLeft = len(ids)
+			}
+			if len(left) != wantLeft {
+				t.Errorf("%d rollbacks left, want %d", len(left), wantLeft)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+func sessionLockHeld(path string) (bool, error) {
+	_, err := os.Stat(path)
+	return false, err
+}
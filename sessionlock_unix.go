@@ -0,0 +1,36 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// sessionLockHeld reports whether another process holds a lock on the session.lock
+// at path. Java locks with fcntl, so that lock is asked about rather than taken;
+// flock is tried too, as Linux keeps the two kinds apart.
+func sessionLockHeld(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	lk := unix.Flock_t{Type: unix.F_WRLCK}
+	if err := unix.FcntlFlock(f.Fd(), unix.F_GETLK, &lk); err != nil {
+		return false, err
+	}
+	if lk.Type != unix.F_UNLCK {
+		return true, nil
+	}
+	fd := int(f.Fd())
+	if err := unix.Flock(fd, unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, unix.Flock(fd, unix.LOCK_UN)
+}
@@ -0,0 +1,699 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The backup store keeps world backups as content-addressed chunks so a snapshot
+// only adds the data that changed since the last one. Region files are split at
+// Minecraft chunk boundaries, so a chunk the game did not touch is stored once no
+// matter where in the file it moved; everything else is cut into fixed pieces.
+//
+// Layout, all under the store folder:
+//
+//	chunks/ab/abcdef...   one piece of file data, named by the SHA-256 of its contents
+//	snapshots/<id>.json   one snapshot: its files and, per file, the hash of a chunk
+//	                      that lists the file's pieces in order
+const (
+	storeMaxChunk    = 1 << 20
+	regionSectorSize = 4096
+	regionHeaderSize = 2 * regionSectorSize
+	// region files larger than this are cut into fixed pieces instead of read whole
+	regionMaxSplit = 64 << 20
+)
+
+// Every stored chunk starts with one byte saying how the rest is encoded.
+const (
+	chunkRaw     byte = 0
+	chunkDeflate byte = 1
+)
+
+func backupStoreDir(backupDir string) string {
+	return filepath.Join(backupDir, "store")
+}
+
+type backupStore struct {
+	dir string
+}
+
+func openBackupStore(dir string) (*backupStore, error) {
+	for _, sub := range []string{"chunks", "snapshots"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &backupStore{dir: dir}, nil
+}
+
+type snapshotFile struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"modTime"`
+	Mode    fs.FileMode `json:"mode"`
+	// List is the chunk holding the hashes of the file's chunks, one per line.
+	List string `json:"list"`
+}
+
+type storeSnapshot struct {
+	ID       string    `json:"id"`
+	Instance string    `json:"instance"`
+	Created  time.Time `json:"created"`
+	Entries  []string  `json:"entries"`
+	Worlds   []string  `json:"worlds,omitempty"`
+	// Dirs are the folders, so empty ones come back on restore too.
+	Dirs  []string       `json:"dirs,omitempty"`
+	Bytes int64          `json:"bytes"`
+	Added int64          `json:"added"`
+	Files []snapshotFile `json:"files"`
+}
+
+func (s *backupStore) chunkPath(hash string) string {
+	return filepath.Join(s.dir, "chunks", hash[:2], hash)
+}
+
+func (s *backupStore) snapshotPath(id string) string {
+	return filepath.Join(s.dir, "snapshots", id+".json")
+}
+
+// put stores data unless a chunk with the same contents exists and returns its
+// hash and the number of bytes it added to the store.
+func (s *backupStore) put(data []byte, compress bool) (string, int64, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := s.chunkPath(hash)
+	if pathExists(path) {
+		return hash, 0, nil
+	}
+	var buf bytes.Buffer
+	if compress {
+		buf.WriteByte(chunkDeflate)
+		zw, err := flate.NewWriter(&buf, flate.BestSpeed)
+		if err != nil {
+			return "", 0, err
+		}
+		if _, err := zw.Write(data); err != nil {
+			return "", 0, err
+		}
+		if err := zw.Close(); err != nil {
+			return "", 0, err
+		}
+	} else {
+		buf.WriteByte(chunkRaw)
+		buf.Write(data)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, err
+	}
+	return hash, int64(buf.Len()), nil
+}
+
+var errChunkCorrupt = errors.New("chunk contents do not match its hash")
+
+// get reads a chunk and checks it against its hash.
+func (s *backupStore) get(hash string) ([]byte, error) {
+	raw, err := os.ReadFile(s.chunkPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, errChunkCorrupt
+	}
+	data := raw[1:]
+	switch raw[0] {
+	case chunkRaw:
+	case chunkDeflate:
+		if data, err = io.ReadAll(flate.NewReader(bytes.NewReader(raw[1:]))); err != nil {
+			return nil, errChunkCorrupt
+		}
+	default:
+		return nil, errChunkCorrupt
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, errChunkCorrupt
+	}
+	return data, nil
+}
+
+func (s *backupStore) chunkList(hash string) ([]string, error) {
+	data, err := s.get(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(data), "\n"), nil
+}
+
+func (s *backupStore) saveSnapshot(snap *storeSnapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := s.snapshotPath(snap.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *backupStore) snapshot(id string) (*storeSnapshot, error) {
+	data, err := os.ReadFile(s.snapshotPath(id))
+	if err != nil {
+		return nil, err
+	}
+	snap := &storeSnapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("read snapshot %s: %w", id, err)
+	}
+	return snap, nil
+}
+
+// snapshots returns every snapshot in the store, newest first.
+func (s *backupStore) snapshots() ([]*storeSnapshot, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "snapshots"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snaps []*storeSnapshot
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		snap, err := s.snapshot(id)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(a, b int) bool { return snaps[a].Created.After(snaps[b].Created) })
+	return snaps, nil
+}
+
+// take snapshots the planned entries. Files whose size and modification time
+// match the instance's previous snapshot reuse its chunk list without being read.
+func (s *backupStore) take(ctx context.Context, bp *backupPlan, progress func(done, total int64)) (*storeSnapshot, error) {
+	now := time.Now()
+	snap := &storeSnapshot{
+		ID:       fmt.Sprintf("%s-%s", filepath.Base(bp.Instance), now.Format("20060102-150405")),
+		Instance: bp.Instance,
+		Created:  now,
+		Entries:  bp.Entries,
+	}
+	for n := 2; pathExists(s.snapshotPath(snap.ID)); n++ {
+		snap.ID = fmt.Sprintf("%s-%s-%d", filepath.Base(bp.Instance), now.Format("20060102-150405"), n)
+	}
+	previous := make(map[string]snapshotFile)
+	if snaps, err := s.snapshots(); err == nil {
+		for _, old := range snaps {
+			if old.Instance == bp.Instance {
+				for _, f := range old.Files {
+					previous[f.Path] = f
+				}
+				break
+			}
+		}
+	}
+
+	var paths []string
+	for _, entry := range bp.Entries {
+		err := walkBackupEntry(bp.Root, entry, func(rel string, info fs.FileInfo) error {
+			if info.IsDir() {
+				if entry == "saves" && filepath.Dir(rel) == "saves" {
+					snap.Worlds = append(snap.Worlds, filepath.Base(rel))
+				}
+				snap.Dirs = append(snap.Dirs, filepath.ToSlash(rel))
+				return nil
+			}
+			paths = append(paths, rel)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry, err)
+		}
+	}
+	sort.Strings(snap.Worlds)
+
+	snap.Files = make([]snapshotFile, len(paths))
+	var (
+		done, added atomic.Int64
+		wg          sync.WaitGroup
+		once        sync.Once
+		firstErr    error
+	)
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	queue := make(chan int)
+	for w := 0; w < copyWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if runCtx.Err() != nil {
+					continue
+				}
+				f, n, err := s.storeFile(runCtx, filepath.Join(bp.Root, paths[i]), filepath.ToSlash(paths[i]), previous)
+				if err != nil {
+					once.Do(func() {
+						firstErr = fmt.Errorf("%s: %w", paths[i], err)
+						cancel()
+					})
+					continue
+				}
+				snap.Files[i] = f
+				added.Add(n)
+				if progress != nil {
+					progress(done.Add(f.Size), bp.Bytes)
+				}
+			}
+		}()
+	}
+feed:
+	for i := range paths {
+		select {
+		case queue <- i:
+		case <-runCtx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, f := range snap.Files {
+		snap.Bytes += f.Size
+	}
+	snap.Added = added.Load()
+	if err := s.saveSnapshot(snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// newBytes estimates how much a snapshot of bp adds to the store. Files whose
+// size and modification time match the instance's last snapshot add nothing; the
+// rest are counted in full, as if no chunk of them were stored yet.
+func (s *backupStore) newBytes(bp *backupPlan) (int64, error) {
+	snaps, err := s.snapshots()
+	if err != nil {
+		return 0, err
+	}
+	previous := make(map[string]snapshotFile)
+	for _, old := range snaps {
+		if old.Instance == bp.Instance {
+			for _, f := range old.Files {
+				previous[f.Path] = f
+			}
+			break
+		}
+	}
+	var added int64
+	for _, entry := range bp.Entries {
+		err := walkBackupEntry(bp.Root, entry, func(rel string, info fs.FileInfo) error {
+			if info.IsDir() {
+				return nil
+			}
+			if old, ok := previous[filepath.ToSlash(rel)]; ok && old.Size == info.Size() && old.ModTime.Equal(info.ModTime()) {
+				return nil
+			}
+			added += info.Size()
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", entry, err)
+		}
+	}
+	return added, nil
+}
+
+// storeFile stores one file and returns its snapshot entry and the bytes it added.
+func (s *backupStore) storeFile(ctx context.Context, path, rel string, previous map[string]snapshotFile) (snapshotFile, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return snapshotFile{}, 0, err
+	}
+	f := snapshotFile{Path: rel, Size: info.Size(), ModTime: info.ModTime(), Mode: info.Mode().Perm()}
+	if old, ok := previous[rel]; ok && old.Size == f.Size && old.ModTime.Equal(f.ModTime) && pathExists(s.chunkPath(old.List)) {
+		f.List = old.List
+		return f, 0, nil
+	}
+	compress := compressibleFile(path)
+	var (
+		hashes []string
+		added  int64
+	)
+	err = splitFile(ctx, path, info.Size(), func(piece []byte) error {
+		hash, n, err := s.put(piece, compress)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, hash)
+		added += n
+		return nil
+	})
+	if err != nil {
+		return f, added, err
+	}
+	list, n, err := s.put([]byte(strings.Join(hashes, "\n")), true)
+	if err != nil {
+		return f, added, err
+	}
+	f.List = list
+	return f, added + n, nil
+}
+
+// compressibleFile reports whether deflating the file's chunks is worth it;
+// region files and archives are compressed already.
+func compressibleFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mca", ".mcr", ".zip", ".gz", ".png", ".jpg", ".jar":
+		return false
+	}
+	return true
+}
+
+// splitFile calls emit with the file's pieces in order.
+func splitFile(ctx context.Context, path string, size int64, emit func([]byte) error) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if (ext == ".mca" || ext == ".mcr") && size <= regionMaxSplit {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, piece := range splitRegion(data) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := emit(piece); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := make([]byte, storeMaxChunk)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			if err := emit(append([]byte(nil), buf[:n]...)); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// splitRegion cuts a region file into its header and one piece per Minecraft
+// chunk, each running up to where the next chunk starts. Files whose header does
+// not make sense are cut into fixed pieces.
+func splitRegion(data []byte) [][]byte {
+	if len(data) < regionHeaderSize {
+		return splitFixed(data)
+	}
+	cuts := []int{0, regionHeaderSize}
+	for i := 0; i < 1024; i++ {
+		loc := binary.BigEndian.Uint32(data[i*4:])
+		offset := int(loc>>8) * regionSectorSize
+		if offset == 0 {
+			continue
+		}
+		if offset < regionHeaderSize || offset >= len(data) {
+			return splitFixed(data)
+		}
+		cuts = append(cuts, offset)
+	}
+	sort.Ints(cuts)
+	var pieces [][]byte
+	for i, start := range cuts {
+		if i > 0 && start == cuts[i-1] {
+			continue
+		}
+		end := len(data)
+		for _, next := range cuts[i+1:] {
+			if next > start {
+				end = next
+				break
+			}
+		}
+		pieces = append(pieces, splitFixed(data[start:end])...)
+	}
+	return pieces
+}
+
+func splitFixed(data []byte) [][]byte {
+	var pieces [][]byte
+	for len(data) > storeMaxChunk {
+		pieces = append(pieces, data[:storeMaxChunk])
+		data = data[storeMaxChunk:]
+	}
+	return append(pieces, data)
+}
+
+// restore writes the snapshot's files whose path starts with prefix (all of them
+// when prefix is empty) under target, checking every chunk on the way.
+func (s *backupStore) restore(ctx context.Context, snap *storeSnapshot, prefix, target string, progress func(done, total int64)) error {
+	under := func(p string) bool {
+		return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+	var (
+		files       []snapshotFile
+		dirs        []string
+		total, done int64
+	)
+	for _, f := range snap.Files {
+		if under(f.Path) {
+			files = append(files, f)
+			total += f.Size
+		}
+	}
+	for _, d := range snap.Dirs {
+		if under(d) {
+			dirs = append(dirs, d)
+		}
+	}
+	if len(files) == 0 && len(dirs) == 0 {
+		return fmt.Errorf("snapshot %s has nothing under %s", snap.ID, prefix)
+	}
+	for _, d := range dirs {
+		if err := os.MkdirAll(filepath.Join(target, filepath.FromSlash(d)), 0o755); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hashes, err := s.chunkList(f.List)
+		if err != nil {
+			return fmt.Errorf("%s: chunk list %s: %w", f.Path, f.List, err)
+		}
+		dst := filepath.Join(target, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, f.Mode|0o200)
+		if err != nil {
+			return err
+		}
+		for _, h := range hashes {
+			data, err := s.get(h)
+			if err == nil {
+				_, err = out.Write(data)
+			}
+			if err != nil {
+				out.Close()
+				return fmt.Errorf("%s: chunk %s: %w", f.Path, h, err)
+			}
+			done += int64(len(data))
+			if progress != nil {
+				progress(done, total)
+			}
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		_ = os.Chtimes(dst, f.ModTime, f.ModTime)
+	}
+	return nil
+}
+
+// storeCheck is the outcome of an integrity check.
+type storeCheck struct {
+	Snapshots int `json:"snapshots"`
+	Chunks    int `json:"chunks"`
+	// Damaged maps a snapshot to the files in it that cannot be restored.
+	Damaged map[string][]string `json:"damaged,omitempty"`
+}
+
+// check reads every chunk the snapshots use and verifies it against its hash.
+func (s *backupStore) check(ctx context.Context, progress func(done, total int64)) (*storeCheck, error) {
+	snaps, err := s.snapshots()
+	if err != nil {
+		return nil, err
+	}
+	res := &storeCheck{Snapshots: len(snaps), Damaged: make(map[string][]string)}
+	good := make(map[string]bool)
+	verify := func(hash string) bool {
+		ok, seen := good[hash]
+		if !seen {
+			_, err := s.get(hash)
+			ok = err == nil
+			good[hash] = ok
+			res.Chunks++
+		}
+		return ok
+	}
+	var total, done int64
+	for _, snap := range snaps {
+		total += snap.Bytes
+	}
+	for _, snap := range snaps {
+		for _, f := range snap.Files {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			intact := verify(f.List)
+			if intact {
+				hashes, _ := s.chunkList(f.List)
+				for _, h := range hashes {
+					if !verify(h) {
+						intact = false
+					}
+				}
+			}
+			if !intact {
+				res.Damaged[snap.ID] = append(res.Damaged[snap.ID], f.Path)
+			}
+			done += f.Size
+			if progress != nil {
+				progress(done, total)
+			}
+		}
+	}
+	return res, nil
+}
+
+// gc deletes the chunks no snapshot uses any more.
+func (s *backupStore) gc() (removed int, freed int64, err error) {
+	snaps, err := s.snapshots()
+	if err != nil {
+		return 0, 0, err
+	}
+	used := make(map[string]bool)
+	for _, snap := range snaps {
+		for _, f := range snap.Files {
+			used[f.List] = true
+			hashes, err := s.chunkList(f.List)
+			if err != nil {
+				// keep whatever a damaged list might still point at
+				return 0, 0, fmt.Errorf("snapshot %s: %s: %w", snap.ID, f.Path, err)
+			}
+			for _, h := range hashes {
+				used[h] = true
+			}
+		}
+	}
+	err = filepath.WalkDir(filepath.Join(s.dir, "chunks"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || used[d.Name()] {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	return removed, freed, err
+}
+
+// prune applies the retention policy to the snapshots of instance, or of every
+// instance when instance is empty, and then deletes the chunks nothing uses any
+// more. A snapshot's size is what it added to the store.
+func (s *backupStore) prune(instance string, p *retentionPolicy, dryRun bool) ([]backupItem, error) {
+	snaps, err := s.snapshots()
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string][]backupItem)
+	for _, snap := range snaps {
+		if instance == "" || snap.Instance == instance {
+			groups[snap.Instance] = append(groups[snap.Instance], backupItem{Path: s.snapshotPath(snap.ID), Time: snap.Created, Size: snap.Added})
+		}
+	}
+	dropped, err := pruneBackups(groups, p, dryRun)
+	if err != nil || dryRun || len(dropped) == 0 {
+		return dropped, err
+	}
+	_, _, err = s.gc()
+	return dropped, err
+}
+
+func (snap *storeSnapshot) describe() string {
+	line := fmt.Sprintf("%s  %s  %d files, %s, added %s", snap.ID, snap.Created.Format("2006-01-02 15:04"), len(snap.Files), formatBytes(snap.Bytes), formatBytes(snap.Added))
+	if len(snap.Worlds) > 0 {
+		line += "  worlds: " + strings.Join(snap.Worlds, ", ")
+	}
+	return line
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreGC(t *testing.T) {
+	// shared is in both snapshots unchanged, changed differs between them and
+	// added is only in the second
+	shared := bytes.Repeat([]byte("shared "), 3*storeMaxChunk/7)
+	tests := []struct {
+		name   string
+		remove int
+	}{
+		{"remove the older snapshot", 0},
+		{"remove the newer snapshot", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			root := filepath.Join(dir, "instance")
+			bp := &backupPlan{Instance: root, Root: root, Entries: []string{"saves"}}
+			versions := []map[string][]byte{
+				{"saves/w/shared.dat": shared, "saves/w/changed.dat": []byte("first")},
+				{"saves/w/shared.dat": shared, "saves/w/changed.dat": []byte("second"), "saves/w/added.dat": []byte("new")},
+			}
+			store, err := openBackupStore(filepath.Join(dir, "store"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var snaps []*storeSnapshot
+			for n, files := range versions {
+				for rel, data := range files {
+					writeFile(t, filepath.Join(root, rel), data)
+				}
+				snap, err := store.take(context.Background(), bp, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// snapshot ids and order go by time
+				snap.Created = snap.Created.Add(time.Duration(n) * time.Minute)
+				if err := store.saveSnapshot(snap); err != nil {
+					t.Fatal(err)
+				}
+				snaps = append(snaps, snap)
+			}
+
+			if err := os.Remove(store.snapshotPath(snaps[tt.remove].ID)); err != nil {
+				t.Fatal(err)
+			}
+			removed, _, err := store.gc()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if removed == 0 {
+				t.Error("gc removed nothing the other snapshot did not use")
+			}
+			check, err := store.check(context.Background(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(check.Damaged) > 0 {
+				t.Fatalf("gc removed chunks in use: %v", check.Damaged)
+			}
+			kept := snaps[1-tt.remove]
+			target := filepath.Join(dir, "restored")
+			if err := store.restore(context.Background(), kept, "", target, nil); err != nil {
+				t.Fatal(err)
+			}
+			for rel, want := range versions[1-tt.remove] {
+				got, err := os.ReadFile(filepath.Join(target, rel))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s restored with %d bytes, want %d", rel, len(got), len(want))
+				}
+			}
+		})
+	}
+}
+
+func TestStoreFollowsLinks(t *testing.T) {
+	instance, want := linkedSaves(t)
+	bp, err := planBackup(instance, &config{BackupDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := openBackupStore(backupStoreDir(bp.Dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := store.take(context.Background(), bp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Files) != len(want) || len(snap.Worlds) != 1 || snap.Worlds[0] != "w" {
+		t.Fatalf("snapshot has %d files and worlds %v, want %d files and [w]", len(snap.Files), snap.Worlds, len(want))
+	}
+	target := t.TempDir()
+	if err := store.restore(context.Background(), snap, "", target, nil); err != nil {
+		t.Fatal(err)
+	}
+	for rel, content := range want {
+		path := filepath.Join(target, rel)
+		info, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.Mode().IsRegular() || string(data) != content {
+			t.Errorf("%s restored as %v holding %q, want a file holding %q", rel, info.Mode(), data, content)
+		}
+	}
+}
+
+func TestStoreNewBytes(t *testing.T) {
+	instance, _ := linkedSaves(t)
+	bp, err := planBackup(instance, &config{BackupDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := openBackupStore(backupStoreDir(bp.Dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	estimate := func() int64 {
+		t.Helper()
+		n, err := store.newBytes(bp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := estimate(); n != bp.Bytes {
+		t.Errorf("empty store: %d new bytes, want all %d", n, bp.Bytes)
+	}
+	if _, err := store.take(context.Background(), bp, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := estimate(); n != 0 {
+		t.Errorf("right after a snapshot: %d new bytes, want 0", n)
+	}
+	level := filepath.Join(bp.Root, "saves", "w", "level.dat")
+	writeFile(t, level, []byte("level, saved again"))
+	if err := os.Chtimes(level, time.Time{}, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if n := estimate(); n != int64(len("level, saved again")) {
+		t.Errorf("after a world save: %d new bytes, want %d", n, len("level, saved again"))
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// transferMode says how a migrated entry's data gets into the new instance.
+type transferMode string
+
+const (
+	// modeCopy duplicates every byte.
+	modeCopy transferMode = "copy"
+	// modeHardlink shares the file between both instances; changes show up in both.
+	modeHardlink transferMode = "hardlink"
+	// modeReflink clones the file copy-on-write where the filesystem supports it.
+	modeReflink transferMode = "reflink"
+	// modeMove takes the data out of the source instance.
+	modeMove transferMode = "move"
+)
+
+var transferModes = []transferMode{modeCopy, modeHardlink, modeReflink, modeMove}
+
+var errReflinkUnsupported = errors.New("copy-on-write clones are not supported on this platform")
+
+func parseTransferMode(s string) (transferMode, error) {
+	for _, m := range transferModes {
+		if string(m) == strings.ToLower(strings.TrimSpace(s)) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown transfer mode %q (want copy, hardlink, reflink or move)", s)
+}
+
+// next cycles through the modes for the TUI picker.
+func (m transferMode) next() transferMode {
+	for i, mode := range transferModes {
+		if mode == m {
+			return transferModes[(i+1)%len(transferModes)]
+		}
+	}
+	return modeCopy
+}
+
+// transferStats counts what a transfer did, including the files that could not use
+// the requested mode and were copied instead.
+type transferStats struct {
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+	Fallbacks int    `json:"fallbacks,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	// Filtered and FilteredBytes count the files the exclusion rules left out.
+	Filtered      int   `json:"filtered,omitempty"`
+	FilteredBytes int64 `json:"filteredBytes,omitempty"`
+	// Notes are symbolic links that could not be carried over as they were.
+	Notes []string `json:"notes,omitempty"`
+}
+
+func (s *transferStats) fellBack(err error) {
+	s.Fallbacks++
+	if s.Reason == "" {
+		s.Reason = err.Error()
+	}
+}
+
+// transferFile puts src at dst using mode, falling back to a plain copy when the
+// mode is not possible here, e.g. links across devices or filesystems without
+// clones. fallback is the reason when that happened. onBytes receives the bytes
+// written by a copy as it goes.
+func transferFile(ctx context.Context, src, dst string, mode transferMode, onBytes func(int64)) (fallback, err error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return nil, err
+	}
+	switch mode {
+	case modeHardlink:
+		_ = os.Remove(dst)
+		if fallback = os.Link(src, dst); fallback == nil {
+			return nil, nil
+		}
+	case modeReflink:
+		if fallback = cloneFile(src, dst); fallback == nil {
+			if info, err := os.Stat(src); err == nil {
+				keepMetadata(dst, info)
+			}
+			return nil, nil
+		}
+	case modeMove:
+		if fallback = os.Rename(src, dst); fallback == nil {
+			return nil, nil
+		}
+		if err := copyFileContext(ctx, src, dst, onBytes); err != nil {
+			return fallback, err
+		}
+		return fallback, os.Remove(src)
+	}
+	return fallback, copyFileContext(ctx, src, dst, onBytes)
+}
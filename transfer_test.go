@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransferFile(t *testing.T) {
+	const content = "level data"
+	tests := []struct {
+		mode transferMode
+		// sameFile is whether the destination is the source's file, linked or
+		// renamed rather than copied
+		sameFile   bool
+		sourceGone bool
+	}{
+		{modeCopy, false, false},
+		{modeHardlink, true, false},
+		{modeReflink, false, false},
+		{modeMove, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			dir := t.TempDir()
+			src, dst := filepath.Join(dir, "old", "level.dat"), filepath.Join(dir, "new", "saves", "level.dat")
+			if err := os.MkdirAll(filepath.Dir(src), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			srcInfo, err := os.Stat(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var written int64
+			fallback, err := transferFile(context.Background(), src, dst, tt.mode, func(n int64) { written += n })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fallback != nil && tt.mode != modeReflink {
+				t.Errorf("fell back to a copy: %v", fallback)
+			}
+			if (tt.mode == modeCopy || fallback != nil) && written != int64(len(content)) {
+				t.Errorf("reported %d bytes written, want %d", written, len(content))
+			}
+
+			data, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != content {
+				t.Errorf("destination holds %q, want %q", data, content)
+			}
+			dstInfo, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if os.SameFile(srcInfo, dstInfo) != tt.sameFile {
+				t.Errorf("same file as the source: %v, want %v", !tt.sameFile, tt.sameFile)
+			}
+			if gone := !pathExists(src); gone != tt.sourceGone {
+				t.Errorf("source removed: %v, want %v", gone, tt.sourceGone)
+			}
+		})
+	}
+}
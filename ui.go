@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/progress"
@@ -22,6 +25,31 @@ type model struct {
 	quitting         bool
 	step             int
 	statusMessage    string
+	updates          chan progressUpdate
+	questions        chan conflictQuestion
+	question         conflictQuestion
+	cancel           context.CancelFunc
+	plan             *migrationPlan
+	report           *migrationReport
+	userMods         checklist
+	modeCursor       int
+	worlds           checklist
+	categories       checklist
+	rollbacks        []*rollbackJournal
+	rollbackCursor   int
+	backups          []availableBackup
+	backupCursor     int
+	// restoreScopes are the worlds of the picked backup; "" restores all of it.
+	restoreScopes  []string
+	scopeCursor    int
+	restoreWarning string
+	// gameRunning is why the migration was refused; waitingForGame retries it
+	// once the game closes.
+	gameRunning    *gameRunningError
+	waitingForGame bool
+	// saveWarning says a choice could not be saved as the default; the plan and
+	// progress screens show it.
+	saveWarning string
 }
 
 const (
@@ -29,7 +57,19 @@ const (
 	stepListInstances
 	stepPickVersion
 	stepPromptDest
+	stepPlanning
+	stepPlan
+	stepTransferModes
+	stepWorlds
+	stepCategories
+	stepConfirmUpgrade
 	stepProgress
+	stepUserMods
+	stepRollbacks
+	stepBackups
+	stepRestoreScope
+	stepGameRunning
+	stepConflict
 	stepDone
 )
 
@@ -54,8 +94,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.text.Placeholder = "Invalid path. Try again."
 					break
 				}
-				cfg := &config{InstancesDir: path}
-				_ = saveConfig(cfg)
+				_ = updateConfig(func(cfg *config) { cfg.InstancesDir = path })
 				dirs, err := listDirectories(path)
 				if err != nil {
 					m.text.Placeholder = "Failed to read directory. Try another."
@@ -75,18 +114,51 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "q", "ctrl+c":
 				m.quitting = true
 				return m, tea.Quit
+			case "r":
+				i, ok := m.list.SelectedItem().(item)
+				if !ok {
+					break
+				}
+				m.selectedInstance = string(i)
+				journals, err := listRollbacks(m.instancePath())
+				if err != nil {
+					m.choice = fmt.Sprintf("Reading rollbacks failed: %v", err)
+					m.step = stepDone
+					return m, tea.Quit
+				}
+				m.rollbacks = journals
+				m.rollbackCursor = 0
+				m.step = stepRollbacks
+				return m, nil
+			case "b":
+				i, ok := m.list.SelectedItem().(item)
+				if !ok {
+					break
+				}
+				m.selectedInstance = string(i)
+				cfg, _ := loadConfig()
+				backups, err := listAvailableBackups(configuredBackupDir(cfg, m.instancePath()))
+				if err != nil {
+					m.choice = fmt.Sprintf("Reading backups failed: %v", err)
+					m.step = stepDone
+					return m, tea.Quit
+				}
+				m.backups = backups
+				m.backupCursor = 0
+				m.step = stepBackups
+				return m, nil
 			case "enter":
 				i, ok := m.list.SelectedItem().(item)
 				if !ok {
 					break
 				}
 				m.selectedInstance = string(i)
-				if cfg, err := loadConfig(); err == nil && cfg != nil {
+				_ = updateConfig(func(cfg *config) {
+					if cfg.InstancesDir == "" {
+						cfg.InstancesDir = m.text.Value()
+					}
 					cfg.InstanceName = m.selectedInstance
-					_ = saveConfig(cfg)
-				} else {
-					_ = saveConfig(&config{InstancesDir: m.text.Value(), InstanceName: m.selectedInstance})
-				}
+				})
 				versions, err := fetchAvailableVersions()
 				if err != nil || len(versions) == 0 {
 					m.list.SetItems([]list.Item{item("No versions available")})
@@ -112,13 +184,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				i, ok := m.list.SelectedItem().(item)
 				if ok {
 					m.selectedVersion = string(i)
-					if cfg, err := loadConfig(); err == nil && cfg != nil {
+					_ = updateConfig(func(cfg *config) {
+						if cfg.InstancesDir == "" {
+							cfg.InstancesDir = m.text.Value()
+						}
+						cfg.InstanceName = m.selectedInstance
 						cfg.SelectedVersion = m.selectedVersion
-						_ = saveConfig(cfg)
-					} else {
-						_ = saveConfig(&config{InstancesDir: m.text.Value(), InstanceName: m.selectedInstance, SelectedVersion: m.selectedVersion})
-					}
-					// proceed to destination prompt (will backup automatically before migrating)
+					})
 					m.text.SetValue("")
 					m.text.Placeholder = "Name for NEW GTNH instance (folder under instances dir)"
 					m.step = stepPromptDest
@@ -127,6 +199,16 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 		case stepPromptDest:
+			if msg.String() == "tab" {
+				cfg, _ := loadConfig()
+				if cfg == nil || cfg.InstancesDir == "" {
+					m.text.SetValue("")
+					m.text.Placeholder = "Instances directory not set. Restart."
+					break
+				}
+				m.step = stepPlanning
+				return m, planUpgradeCmd(filepath.Join(cfg.InstancesDir, m.selectedInstance), m.selectedVersion)
+			}
 			if msg.String() == "enter" {
 				name := strings.TrimSpace(m.text.Value())
 				if name == "" {
@@ -148,9 +230,309 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				sourcePath := filepath.Join(base, m.selectedInstance)
 				destPath := filepath.Join(base, name)
-				return m.beginMigration(sourcePath, destPath)
+				m.step = stepPlanning
+				return m, planCmd(sourcePath, destPath, m.selectedVersion)
 			}
+		case stepPlan:
+			switch msg.String() {
+			case "q", "ctrl+c":
+				m.quitting = true
+				return m, tea.Quit
+			case "esc":
+				m.plan = nil
+				m.step = stepPromptDest
+				return m, m.text.Focus()
+			case "enter":
+				if m.plan.InPlace {
+					m.step = stepConfirmUpgrade
+					return m, nil
+				}
+				if len(m.plan.Entries) == 0 {
+					return m.beginMigration(m.plan)
+				}
+				m.categories = checklist{}
+				for _, e := range m.plan.Entries {
+					detail := fmt.Sprintf("%d files, %s", e.Files, formatBytes(e.Bytes))
+					m.categories.items = append(m.categories.items, checkItem{label: filepath.ToSlash(e.Path), detail: detail, checked: !e.Excluded})
+				}
+				m.step = stepCategories
+				return m, nil
+			case "v":
+				if !m.plan.InPlace {
+					m.plan.Verify = !m.plan.Verify
+				}
+			case "b":
+				if !m.plan.InPlace && m.plan.Backup != nil {
+					m.plan.Backup.Enabled = !m.plan.Backup.Enabled
+				}
+			case "p":
+				if m.plan.ChunkPrune != nil {
+					m.plan.ChunkPrune.Enabled = !m.plan.ChunkPrune.Enabled
+				}
+			case "c":
+				if !m.plan.InPlace && m.plan.included("saves") {
+					m.plan.CheckRegions = !m.plan.CheckRegions
+				}
+			case "m":
+				if len(m.plan.dirEntries()) > 0 {
+					m.modeCursor = 0
+					m.step = stepTransferModes
+				}
+			case "w":
+				if !m.plan.InPlace && len(m.plan.Worlds) > 0 {
+					m.worlds = checklist{}
+					for _, w := range m.plan.Worlds {
+						detail := formatBytes(w.Bytes)
+						if w.LastPlayed != nil {
+							detail += ", last played " + w.LastPlayed.Format("2006-01-02 15:04")
+						}
+						label := w.Folder
+						if w.Name != "" && w.Name != w.Folder {
+							label += fmt.Sprintf(" (%s)", w.Name)
+						}
+						m.worlds.items = append(m.worlds.items, checkItem{label: label, detail: detail, checked: !w.Skip})
+					}
+					m.step = stepWorlds
+				}
+			}
+			return m, nil
+		case stepCategories:
+			switch msg.String() {
+			case "ctrl+c":
+				m.quitting = true
+				return m, tea.Quit
+			case "enter":
+				var excluded []string
+				for i, it := range m.categories.items {
+					if !it.checked {
+						excluded = append(excluded, m.plan.Entries[i].Path)
+					}
+				}
+				m.plan.excludeEntries(excluded)
+				if err := saveExcludedEntries(m.plan); err != nil {
+					m.saveWarning = fmt.Sprintf("Could not save what to carry over as the default: %v", err)
+				}
+				return m.beginMigration(m.plan)
+			case "esc":
+				m.step = stepPlan
+			default:
+				m.categories.update(msg.String())
+			}
+			return m, nil
+		case stepWorlds:
+			switch msg.String() {
+			case "ctrl+c":
+				m.quitting = true
+				return m, tea.Quit
+			case "enter":
+				var skip []string
+				for i, it := range m.worlds.items {
+					if !it.checked {
+						skip = append(skip, m.plan.Worlds[i].Folder)
+					}
+				}
+				if err := m.plan.skipWorlds(skip); err == nil {
+					if err := saveSkippedWorlds(m.plan); err != nil {
+						m.saveWarning = fmt.Sprintf("Could not save the world choice for this instance: %v", err)
+					}
+				}
+				m.step = stepPlan
+			case "esc":
+				m.step = stepPlan
+			default:
+				m.worlds.update(msg.String())
+			}
+			return m, nil
+		case stepTransferModes:
+			dirs := m.plan.dirEntries()
+			switch msg.String() {
+			case "ctrl+c":
+				m.quitting = true
+				return m, tea.Quit
+			case "up", "k":
+				if m.modeCursor > 0 {
+					m.modeCursor--
+				}
+			case "down", "j":
+				if m.modeCursor < len(dirs)-1 {
+					m.modeCursor++
+				}
+			case " ", "right", "l", "tab":
+				e := dirs[m.modeCursor]
+				_ = m.plan.setMode(e.Path, e.Mode.next())
+			case "enter", "esc":
+				if err := saveTransferModes(m.plan); err != nil {
+					m.saveWarning = fmt.Sprintf("Could not save the transfer modes as the default: %v", err)
+				}
+				m.step = stepPlan
+			}
+			return m, nil
+		case stepConfirmUpgrade:
+			switch msg.String() {
+			case "ctrl+c":
+				m.quitting = true
+				return m, tea.Quit
+			case "y":
+				return m.beginMigration(m.plan)
+			case "n", "esc":
+				m.step = stepPlan
+			}
+			return m, nil
+		case stepRollbacks:
+			switch msg.String() {
+			case "ctrl+c", "q":
+				m.quitting = true
+				return m, tea.Quit
+			case "esc":
+				m.step = stepListInstances
+			case "up", "k":
+				if m.rollbackCursor > 0 {
+					m.rollbackCursor--
+				}
+			case "down", "j":
+				if m.rollbackCursor < len(m.rollbacks)-1 {
+					m.rollbackCursor++
+				}
+			case "enter":
+				if len(m.rollbacks) > 0 {
+					m.statusMessage = "Rolling back..."
+					m.step = stepProgress
+					return m, rollbackCmd(m.instancePath(), m.rollbacks[m.rollbackCursor].ID)
+				}
+			}
+			return m, nil
+		case stepBackups:
+			switch msg.String() {
+			case "ctrl+c", "q":
+				m.quitting = true
+				return m, tea.Quit
+			case "esc":
+				m.step = stepListInstances
+			case "up", "k":
+				if m.backupCursor > 0 {
+					m.backupCursor--
+				}
+			case "down", "j":
+				if m.backupCursor < len(m.backups)-1 {
+					m.backupCursor++
+				}
+			case "enter":
+				if len(m.backups) > 0 {
+					m.restoreScopes = append([]string{""}, m.backups[m.backupCursor].Worlds...)
+					m.scopeCursor = 0
+					m.restoreWarning = ""
+					m.step = stepRestoreScope
+				}
+			}
+			return m, nil
+		case stepRestoreScope:
+			switch msg.String() {
+			case "ctrl+c", "q":
+				m.quitting = true
+				return m, tea.Quit
+			case "esc":
+				m.step = stepBackups
+			case "up", "k":
+				if m.scopeCursor > 0 {
+					m.scopeCursor--
+					m.restoreWarning = ""
+				}
+			case "down", "j":
+				if m.scopeCursor < len(m.restoreScopes)-1 {
+					m.scopeCursor++
+					m.restoreWarning = ""
+				}
+			case "enter", "f":
+				force := msg.String() == "f"
+				if force && m.restoreWarning == "" {
+					break
+				}
+				m.statusMessage = "Restoring..."
+				m.step = stepProgress
+				return m, restoreCmd(m.backups[m.backupCursor], m.restoreScopes[m.scopeCursor], m.instancePath(), force)
+			}
+			return m, nil
+		case stepGameRunning:
+			switch msg.String() {
+			case "ctrl+c", "q":
+				m.quitting = true
+				return m, tea.Quit
+			case "esc":
+				m.waitingForGame = false
+				m.step = stepPlan
+			case "enter", "r":
+				m.waitingForGame = false
+				return m.beginMigration(m.plan)
+			case "w":
+				if !m.waitingForGame {
+					m.waitingForGame = true
+					return m, waitForGameCmd(m.plan.Source)
+				}
+			}
+			return m, nil
+		case stepConflict:
+			answers := map[string]conflictPolicy{"o": conflictOverwrite, "n": conflictKeepNew, "b": conflictKeepBoth, "m": conflictMerge}
+			key := msg.String()
+			if key == "ctrl+c" {
+				m.cancel()
+				m.statusMessage = "Cancelling..."
+				m.step = stepProgress
+				return m, nil
+			}
+			policy, ok := answers[strings.ToLower(key)]
+			if !ok || policy == conflictMerge && !m.question.conflict.CanMerge {
+				return m, nil
+			}
+			m.question.reply <- conflictAnswer{Policy: policy, All: key != strings.ToLower(key)}
+			m.step = stepProgress
+			return m, waitForQuestion(m.questions)
+		case stepProgress:
+			if msg.String() == "ctrl+c" && m.cancel != nil {
+				m.cancel()
+				m.statusMessage = "Cancelling..."
+			}
+			return m, nil
+		case stepUserMods:
+			switch msg.String() {
+			case "ctrl+c":
+				m.quitting = true
+				return m, tea.Quit
+			case "enter", "esc":
+				var chosen []userMod
+				if msg.String() == "enter" {
+					for _, idx := range m.userMods.checked() {
+						chosen = append(chosen, m.report.Mods.UserMods[idx])
+					}
+				}
+				copied, err := copyUserMods(chosen, m.plan.Destination, m.plan.DestRoot)
+				m.report.Mods.Copied = copied
+				m.choice = completionMessage(m.plan, m.report)
+				if err != nil {
+					m.choice += fmt.Sprintf("\nCopying your mods failed: %v", err)
+				}
+				m.step = stepDone
+				return m, tea.Quit
+			}
+			m.userMods.update(msg.String())
+			return m, nil
+		}
+	case conflictQuestion:
+		m.question = msg
+		m.step = stepConflict
+		return m, nil
+	case progressUpdate:
+		if m.step == stepConflict {
+			return m, waitForProgress(m.updates)
+		}
+		if m.step != stepProgress {
+			return m, nil
 		}
+		m.statusMessage = describeProgress(msg)
+		cmds := []tea.Cmd{waitForProgress(m.updates)}
+		if msg.total > 0 {
+			cmds = append(cmds, m.progress.SetPercent(float64(msg.done)/float64(msg.total)))
+		}
+		return m, tea.Batch(cmds...)
 	case progress.FrameMsg:
 		if m.step == stepProgress {
 			pm, cmd := m.progress.Update(msg)
@@ -160,17 +542,89 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, cmd
 		}
 		return m, nil
+	case rollbackDoneMsg:
+		var lines []string
+		for _, j := range msg.undone {
+			lines = append(lines, fmt.Sprintf("Rolled back %s (%s)", j.ID, j.Release))
+		}
+		if msg.err != nil {
+			lines = append(lines, fmt.Sprintf("Rollback failed: %v", msg.err))
+		}
+		m.choice = strings.Join(lines, "\n")
+		m.step = stepDone
+		return m, tea.Quit
+	case restoreDoneMsg:
+		var newer *newerDataError
+		if errors.As(msg.err, &newer) {
+			m.restoreWarning = newer.Error()
+			m.step = stepRestoreScope
+			return m, nil
+		}
+		if msg.err != nil {
+			m.choice = fmt.Sprintf("Restore failed: %v", msg.err)
+		} else {
+			m.choice = fmt.Sprintf("Restored %s from %s into %s", strings.Join(msg.res.Restored, ", "), msg.res.Backup, msg.res.Target)
+		}
+		m.step = stepDone
+		return m, tea.Quit
+	case gameCheckMsg:
+		if m.step != stepGameRunning || !m.waitingForGame {
+			return m, nil
+		}
+		var running *gameRunningError
+		if errors.As(msg.err, &running) {
+			m.gameRunning = running
+			return m, waitForGameCmd(m.plan.Source)
+		}
+		m.waitingForGame = false
+		return m.beginMigration(m.plan)
+	case planReadyMsg:
+		if msg.err != nil {
+			m.choice = fmt.Sprintf("Planning failed: %v", msg.err)
+			m.step = stepDone
+			return m, tea.Quit
+		}
+		m.plan = msg.plan
+		m.step = stepPlan
+		return m, nil
 	case progressCompleteMsg:
+		if errors.Is(msg.err, context.Canceled) {
+			m.choice = "Migration cancelled. The new instance was removed and the source is unchanged."
+			if m.plan.InPlace {
+				m.choice = "Upgrade cancelled. The instance is unchanged."
+			}
+			m.step = stepDone
+			return m, tea.Quit
+		}
+		var running *gameRunningError
+		if errors.As(msg.err, &running) {
+			m.gameRunning = running
+			m.step = stepGameRunning
+			return m, nil
+		}
 		if msg.err != nil {
 			m.choice = fmt.Sprintf("Migration failed: %v", msg.err)
-		} else {
-			m.choice = msg.message
+			if m.plan.InPlace {
+				m.choice = fmt.Sprintf("Upgrade failed: %v", msg.err)
+			}
+			m.step = stepDone
+			return m, tea.Quit
 		}
+		m.report = msg.report
+		if md := msg.report.Mods; md != nil && len(md.UserMods) > 0 {
+			m.userMods = userModChecklist(md.UserMods)
+			m.step = stepUserMods
+			return m, nil
+		}
+		m.choice = completionMessage(m.plan, m.report)
 		m.step = stepDone
 		return m, tea.Quit
 	}
 
 	var cmd tea.Cmd
+	if m.step == stepPlan || m.step == stepPlanning || m.step == stepTransferModes || m.step == stepWorlds || m.step == stepCategories || m.step == stepConfirmUpgrade || m.step == stepRollbacks || m.step == stepBackups || m.step == stepRestoreScope || m.step == stepGameRunning || m.step == stepConflict {
+		return m, nil
+	}
 	if m.step == stepPromptPath || m.step == stepPromptDest {
 		m.text, cmd = m.text.Update(msg)
 		return m, cmd
@@ -184,14 +638,156 @@ func (m model) View() string {
 	case stepPromptPath:
 		return "\n" + titleStyle.Render("Enter your instances folder path:") + "\n\n  " + m.text.View() + "\n\n  Press Enter to continue"
 	case stepPromptDest:
-		return "\n" + titleStyle.Render("Enter destination instance path:") + "\n\n  " + m.text.View() + "\n\n  Press Enter to migrate"
+		return "\n" + titleStyle.Render("Enter destination instance path:") + "\n\n  " + m.text.View() + "\n\n  Press Enter to review the migration plan, or Tab to upgrade " + m.selectedInstance + " in place instead"
+	case stepPlanning:
+		return "\n" + titleStyle.Render("Planning migration...") + "\n"
+	case stepPlan:
+		builder := strings.Builder{}
+		builder.WriteString("\n" + titleStyle.Render("Migration plan") + "\n\n")
+		for _, line := range strings.Split(strings.TrimRight(m.plan.String(), "\n"), "\n") {
+			builder.WriteString("  " + line + "\n")
+		}
+		if m.saveWarning != "" {
+			builder.WriteString("\n  " + m.saveWarning + "\n")
+		}
+		if m.plan.InPlace {
+			builder.WriteString("\n  Press Enter to upgrade, Esc to go back, q to quit")
+		} else {
+			builder.WriteString("\n  Press Enter to choose what to carry over, w to pick worlds, m to change how folders are transferred, v to toggle verification, b to toggle the world backup, p to toggle chunk pruning, c to toggle the region check, Esc to go back, q to quit")
+		}
+		return builder.String()
+	case stepConfirmUpgrade:
+		builder := strings.Builder{}
+		builder.WriteString("\n" + titleStyle.Render("Upgrade "+m.plan.Destination+" in place?") + "\n\n")
+		for _, e := range m.plan.Replace {
+			if e.Source != "" {
+				builder.WriteString("  " + filepath.ToSlash(e.Path) + " will be replaced\n")
+			}
+		}
+		builder.WriteString("\n  The current pack folders are moved to a rollback in " + rollbackDir(m.plan.Destination) + " before anything is replaced.\n")
+		builder.WriteString("  Close the game and the launcher first.\n")
+		builder.WriteString("\n  Press y to upgrade, n to go back")
+		return builder.String()
+	case stepCategories:
+		builder := strings.Builder{}
+		builder.WriteString("\n" + titleStyle.Render("What should be carried over?") + "\n\n")
+		builder.WriteString(m.categories.view())
+		builder.WriteString("\n  Space to toggle, a for all, Enter to start the migration (the choice becomes the default), Esc to go back")
+		return builder.String()
+	case stepWorlds:
+		builder := strings.Builder{}
+		builder.WriteString("\n" + titleStyle.Render("Which worlds should be migrated?") + "\n\n")
+		builder.WriteString(m.worlds.view())
+		builder.WriteString("\n  Space to toggle, a for all, Enter to keep the choice for this instance, Esc to cancel")
+		return builder.String()
+	case stepTransferModes:
+		builder := strings.Builder{}
+		builder.WriteString("\n" + titleStyle.Render("How should each folder be transferred?") + "\n\n")
+		for i, e := range m.plan.dirEntries() {
+			line := fmt.Sprintf("%-24s %-8s %10s%s", e.Path, e.Mode, formatBytes(e.Bytes), modeNote(e.Mode))
+			if i == m.modeCursor {
+				builder.WriteString(selectedItemStyle.Render("> "+line) + "\n")
+			} else {
+				builder.WriteString(itemStyle.Render(line) + "\n")
+			}
+		}
+		builder.WriteString("\n  Space to change the mode, Enter to go back to the plan\n")
+		builder.WriteString("  reflink clones files where the filesystem supports it; links and clones fall back to copying")
+		return builder.String()
 	case stepProgress:
 		builder := strings.Builder{}
 		builder.WriteString("\n" + titleStyle.Render("Working...") + "\n\n  ")
 		builder.WriteString(m.progress.View())
 		builder.WriteString("\n\n  " + m.statusMessage)
+		if m.saveWarning != "" {
+			builder.WriteString("\n\n  " + m.saveWarning)
+		}
+		return builder.String()
+	case stepUserMods:
+		builder := strings.Builder{}
+		builder.WriteString("\n" + titleStyle.Render("Copy your own mods to the new instance?") + "\n\n")
+		builder.WriteString(m.userMods.view())
+		builder.WriteString("\n  Space to toggle, a for all, Enter to copy the checked mods, Esc to skip")
+		return builder.String()
+	case stepRollbacks:
+		builder := strings.Builder{}
+		builder.WriteString("\n" + titleStyle.Render("Roll back an in-place upgrade of "+m.selectedInstance) + "\n\n")
+		if len(m.rollbacks) == 0 {
+			builder.WriteString("  No rollbacks available.\n\n  Esc to go back")
+			return builder.String()
+		}
+		for i, j := range m.rollbacks {
+			if i == m.rollbackCursor {
+				builder.WriteString(selectedItemStyle.Render("> "+j.describe()) + "\n")
+			} else {
+				builder.WriteString(itemStyle.Render(j.describe()) + "\n")
+			}
+		}
+		builder.WriteString("\n  Enter restores the instance as it was before the selected upgrade; newer upgrades are undone too.\n")
+		builder.WriteString("  Esc to go back, q to quit")
+		return builder.String()
+	case stepBackups:
+		builder := strings.Builder{}
+		builder.WriteString("\n" + titleStyle.Render("Restore a backup into "+m.selectedInstance) + "\n\n")
+		if len(m.backups) == 0 {
+			builder.WriteString("  No backups available.\n\n  Esc to go back")
+			return builder.String()
+		}
+		for i, b := range m.backups {
+			if i == m.backupCursor {
+				builder.WriteString(selectedItemStyle.Render("> "+b.describe()) + "\n")
+			} else {
+				builder.WriteString(itemStyle.Render(b.describe()) + "\n")
+			}
+		}
+		builder.WriteString("\n  Enter to pick what to restore, Esc to go back, q to quit")
+		return builder.String()
+	case stepRestoreScope:
+		b := m.backups[m.backupCursor]
+		builder := strings.Builder{}
+		builder.WriteString("\n" + titleStyle.Render("Restore from "+b.ID+" into "+m.selectedInstance) + "\n\n")
+		for i, scope := range m.restoreScopes {
+			label := "Everything in the backup (" + strings.Join(b.Entries, ", ") + ")"
+			if scope != "" {
+				label = "World " + scope
+			}
+			if i == m.scopeCursor {
+				builder.WriteString(selectedItemStyle.Render("> "+label) + "\n")
+			} else {
+				builder.WriteString(itemStyle.Render(label) + "\n")
+			}
+		}
+		if m.restoreWarning != "" {
+			builder.WriteString("\n  " + m.restoreWarning + "\n  f to overwrite anyway")
+		}
+		builder.WriteString("\n  Enter replaces the chosen worlds in the instance with the backup's copy.\n")
+		builder.WriteString("  Esc to go back, q to quit")
 		return builder.String()
-	case stepListInstances, stepPickVersion:
+	case stepConflict:
+		c := m.question.conflict
+		builder := strings.Builder{}
+		builder.WriteString("\n" + titleStyle.Render("The new pack has "+c.Path+" too") + "\n\n")
+		builder.WriteString("  Your copy comes from " + filepath.ToSlash(c.Entry) + ". What should the new instance keep?\n\n")
+		builder.WriteString("  o  yours, overwriting the new pack's\n")
+		builder.WriteString("  n  the new pack's, leaving yours out\n")
+		builder.WriteString("  b  both, yours under a .old name\n")
+		if c.CanMerge {
+			builder.WriteString("  m  merge your settings into the new pack's\n")
+		}
+		builder.WriteString("\n  Shift with the letter answers the same for the rest of " + filepath.ToSlash(c.Entry) + ". Ctrl+C cancels the migration.")
+		return builder.String()
+	case stepGameRunning:
+		builder := strings.Builder{}
+		builder.WriteString("\n" + titleStyle.Render("The game is still running") + "\n\n")
+		builder.WriteString("  " + m.gameRunning.Error() + "\n")
+		if m.waitingForGame {
+			builder.WriteString("\n  Waiting for the game to close; the migration starts as soon as it does.\n")
+		}
+		builder.WriteString("\n  Enter to retry now, w to wait and retry, Esc to go back to the plan, q to quit")
+		return builder.String()
+	case stepListInstances:
+		return "\n" + m.list.View() + "\n  r to roll back an in-place upgrade, b to restore a backup into the highlighted instance"
+	case stepPickVersion:
 		return "\n" + m.list.View()
 	case stepDone:
 		return quitTextStyle.Render(m.choice)
@@ -199,7 +795,7 @@ func (m model) View() string {
 	return ""
 }
 
-func (m model) beginMigration(source, dest string) (tea.Model, tea.Cmd) {
+func (m model) beginMigration(plan *migrationPlan) (tea.Model, tea.Cmd) {
 	if m.selectedInstance == "" {
 		m.choice = "No source instance selected."
 		m.step = stepDone
@@ -212,73 +808,174 @@ func (m model) beginMigration(source, dest string) (tea.Model, tea.Cmd) {
 	}
 	m.statusMessage = "Starting migration..."
 	m.step = stepProgress
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.updates = make(chan progressUpdate, 1)
+	m.questions = make(chan conflictQuestion)
 	initCmd := m.progress.SetPercent(0)
-	return m, tea.Batch(initCmd, migrateCmd(source, dest, m.selectedVersion))
+	return m, tea.Batch(initCmd, migrateCmd(ctx, plan, m.updates, m.questions), waitForProgress(m.updates), waitForQuestion(m.questions))
 }
 
-type progressCompleteMsg struct {
-	message string
-	err     error
+type progressUpdate struct {
+	stage string
+	done  int64
+	total int64
 }
 
-func migrateCmd(source, dest, version string) tea.Cmd {
+// waitForProgress delivers the next update from a running migration.
+func waitForProgress(updates <-chan progressUpdate) tea.Cmd {
 	return func() tea.Msg {
-		if err := executeMigration(source, dest, version); err != nil {
-			return progressCompleteMsg{err: err}
+		u, ok := <-updates
+		if !ok {
+			return nil
 		}
-		return progressCompleteMsg{message: fmt.Sprintf("Migration complete! New instance created at %s", dest)}
+		return u
 	}
 }
 
-func executeMigration(source, dest, version string) error {
-	if source == "" {
-		return fmt.Errorf("source instance path is empty")
-	}
-	if !pathExists(source) {
-		return fmt.Errorf("source instance not found: %s", source)
-	}
-	if version == "" || version == "No versions available" {
-		return fmt.Errorf("no GTNH version selected")
+type planReadyMsg struct {
+	plan *migrationPlan
+	err  error
+}
+
+func planCmd(source, dest, version string) tea.Cmd {
+	return func() tea.Msg {
+		plan, err := buildMigrationPlan(source, dest, version)
+		return planReadyMsg{plan: plan, err: err}
 	}
-	if _, err := os.Stat(dest); err == nil {
-		return fmt.Errorf("destination already exists: %s", dest)
-	} else if !os.IsNotExist(err) {
-		return fmt.Errorf("unable to access destination: %w", err)
+}
+
+type rollbackDoneMsg struct {
+	undone []*rollbackJournal
+	err    error
+}
+
+func rollbackCmd(instance, id string) tea.Cmd {
+	return func() tea.Msg {
+		undone, err := rollbackInstance(instance, id)
+		return rollbackDoneMsg{undone: undone, err: err}
 	}
+}
+
+type restoreDoneMsg struct {
+	res *restoreResult
+	err error
+}
 
-	tmpDir, err := os.MkdirTemp("", "gtnh-updater-")
-	if err != nil {
-		return err
+func restoreCmd(b availableBackup, world, instance string, force bool) tea.Cmd {
+	return func() tea.Msg {
+		res, err := restoreBackup(context.Background(), b, world, instance, force)
+		return restoreDoneMsg{res: res, err: err}
 	}
-	defer os.RemoveAll(tmpDir)
+}
 
-	zipPath, err := downloadVersionZip(version, tmpDir, nil)
-	if err != nil {
-		return err
+func planUpgradeCmd(instance, version string) tea.Cmd {
+	return func() tea.Msg {
+		plan, err := buildUpgradePlan(instance, version)
+		return planReadyMsg{plan: plan, err: err}
 	}
+}
+
+// gameCheckMsg is the outcome of looking for the game again while waiting for it
+// to close.
+type gameCheckMsg struct {
+	err error
+}
+
+func waitForGameCmd(instance string) tea.Cmd {
+	return tea.Tick(2*time.Second, func(time.Time) tea.Msg {
+		return gameCheckMsg{err: checkGameStopped(instance)}
+	})
+}
 
-	if err = os.MkdirAll(dest, 0o755); err != nil {
-		return err
+// conflictQuestion asks the user to settle a file conflict of a running
+// migration, which waits for the answer on reply.
+type conflictQuestion struct {
+	conflict fileConflict
+	reply    chan<- conflictAnswer
+}
+
+// waitForQuestion delivers the next conflict a running migration asks about.
+func waitForQuestion(questions <-chan conflictQuestion) tea.Cmd {
+	return func() tea.Msg {
+		q, ok := <-questions
+		if !ok {
+			return nil
+		}
+		return q
 	}
-	cleanupDest := true
-	defer func() {
-		if cleanupDest {
-			_ = os.RemoveAll(dest)
+}
+
+// askConflict sends a conflict to the interface and waits for the answer.
+func askConflict(questions chan<- conflictQuestion) conflictAsker {
+	return func(ctx context.Context, c fileConflict) (conflictAnswer, error) {
+		reply := make(chan conflictAnswer, 1)
+		select {
+		case questions <- conflictQuestion{conflict: c, reply: reply}:
+		case <-ctx.Done():
+			return conflictAnswer{}, ctx.Err()
+		}
+		select {
+		case a := <-reply:
+			return a, nil
+		case <-ctx.Done():
+			return conflictAnswer{}, ctx.Err()
 		}
-	}()
+	}
+}
 
-	if err = extractZip(zipPath, dest, nil); err != nil {
-		return err
+type progressCompleteMsg struct {
+	report *migrationReport
+	err    error
+}
+
+func migrateCmd(ctx context.Context, plan *migrationPlan, updates chan progressUpdate, questions chan conflictQuestion) tea.Cmd {
+	return func() tea.Msg {
+		report, err := executeMigration(ctx, plan, func(stage string, done, total int64) {
+			// drop updates the UI has not caught up with; the next one supersedes them
+			select {
+			case updates <- progressUpdate{stage: stage, done: done, total: total}:
+			default:
+			}
+		}, askConflict(questions))
+		close(updates)
+		close(questions)
+		if err != nil {
+			return progressCompleteMsg{err: err}
+		}
+		return progressCompleteMsg{report: report}
 	}
+}
 
-	if err = maybeFlattenSingleDir(dest); err != nil {
-		return err
+func completionMessage(plan *migrationPlan, report *migrationReport) string {
+	lines := []string{fmt.Sprintf("Migration complete! New instance created at %s", plan.Destination)}
+	if plan.InPlace {
+		lines[0] = fmt.Sprintf("Upgrade complete! %s now runs %s", plan.Destination, plan.Release.FileName)
+	}
+	lines = append(lines, report.summary()...)
+	if path, err := report.save(plan.Destination); err == nil {
+		lines = append(lines, "Full report: "+path)
 	}
+	return strings.Join(lines, "\n")
+}
 
-	if err = migrateInstance(source, dest); err != nil {
-		return err
+func describeProgress(u progressUpdate) string {
+	switch {
+	case (u.stage == stageExtracting || u.stage == stageReplacing) && u.total > 0:
+		return fmt.Sprintf("%s... %d of %d entries", u.stage, u.done, u.total)
+	case (u.stage == stagePruning || u.stage == stageCheckSource || u.stage == stageCheckCopy) && u.total > 0:
+		return fmt.Sprintf("%s... %d of %d regions", u.stage, u.done, u.total)
+	case u.total > 0:
+		return fmt.Sprintf("%s... %s of %s", u.stage, formatBytes(u.done), formatBytes(u.total))
+	default:
+		return fmt.Sprintf("%s... %s", u.stage, formatBytes(u.done))
 	}
+}
 
-	cleanupDest = false
-	return nil
+// instancePath is the full path of the selected instance.
+func (m model) instancePath() string {
+	if cfg, err := loadConfig(); err == nil && cfg != nil && cfg.InstancesDir != "" {
+		return filepath.Join(cfg.InstancesDir, m.selectedInstance)
+	}
+	return filepath.Join(m.text.Value(), m.selectedInstance)
 }
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// An in-place upgrade replaces the folders the pack owns and leaves everything
+// else, including instance.cfg, alone. packGameEntries are relative to the game
+// folder, packInstanceEntries to the instance folder.
+var (
+	packGameEntries     = []string{"mods", "config", "scripts", "resources"}
+	packInstanceEntries = []string{"libraries", "patches", "mmc-pack.json"}
+)
+
+// packEntriesRequired must be in the release archive, so a layout the updater
+// does not understand never leaves the instance without its mods or configs.
+var packEntriesRequired = []string{"mods", "config"}
+
+const (
+	actionReplace    = "replace"
+	destRootExisting = "existing"
+)
+
+// buildUpgradePlan plans an in-place upgrade of instance to version. The config
+// merge and mod comparison are the same steps a migration runs; the user's data is
+// not touched, so there is nothing to copy. The worlds are backed up even with
+// skipBackup set, as there is no source instance to go back to.
+func buildUpgradePlan(instance, version string) (*migrationPlan, error) {
+	if instance == "" {
+		return nil, fmt.Errorf("instance path is empty")
+	}
+	if !pathExists(instance) {
+		return nil, fmt.Errorf("instance not found: %s", instance)
+	}
+	if version == "" || version == "No versions available" {
+		return nil, fmt.Errorf("no GTNH version selected")
+	}
+	release, err := probeRelease(version)
+	if err != nil {
+		return nil, err
+	}
+	plan := &migrationPlan{
+		Source:         instance,
+		Destination:    instance,
+		Release:        release,
+		InPlace:        true,
+		DestRoot:       destinationRoot(instance, pathExists),
+		DestRootSource: destRootExisting,
+	}
+	for _, name := range packGameEntries {
+		e, err := replaceEntry(name, filepath.Join(plan.DestRoot, name))
+		if err != nil {
+			return nil, err
+		}
+		plan.Replace = append(plan.Replace, e)
+	}
+	for _, name := range packInstanceEntries {
+		e, err := replaceEntry(name, filepath.Join(instance, name))
+		if err != nil {
+			return nil, err
+		}
+		plan.Replace = append(plan.Replace, e)
+	}
+	if zr, err := openRemoteZip(release.URL, release.Size); err == nil {
+		plan.Release.Uncompressed = zipUncompressedSize(zr.File)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if plan.Backup, err = planBackup(instance, cfg); err != nil {
+		return nil, err
+	}
+	if plan.Backup != nil {
+		plan.Backup.Enabled = true
+	}
+	plan.Rollbacks = rollbackRetention(cfg.retention())
+	if plan.Worlds, err = planWorlds(filepath.Join(plan.DestRoot, "saves"), packVersion(release.FileName)); err != nil {
+		return nil, fmt.Errorf("read worlds: %w", err)
+	}
+	plan.ConfigMerge = planConfigMerge(instance)
+	plan.Mods = planModDiff(instance)
+	return plan, nil
+}
+
+func replaceEntry(name, current string) (planEntry, error) {
+	// Kind stays empty for entries the instance does not have yet
+	e := planEntry{Path: name, Action: actionReplace}
+	info, err := os.Stat(current)
+	if os.IsNotExist(err) {
+		return e, nil
+	}
+	if err != nil {
+		return e, err
+	}
+	e.Source = current
+	e.Kind = entryDir
+	if !info.IsDir() {
+		e.Kind = entryFile
+		e.Files, e.Bytes = 1, info.Size()
+		return e, nil
+	}
+	if e.Files, e.Bytes, err = dirStats(current); err != nil {
+		return e, fmt.Errorf("scan %s: %w", name, err)
+	}
+	return e, nil
+}
+
+// replaceTarget is where a pack entry lives under root, which is either the
+// instance being upgraded or the staged release.
+func replaceTarget(e planEntry, root string) string {
+	for _, name := range packInstanceEntries {
+		if name == e.Path {
+			return filepath.Join(root, name)
+		}
+	}
+	return filepath.Join(destinationRoot(root, pathExists), e.Path)
+}
+
+type upgradeResult struct {
+	Rollback string   `json:"rollback"`
+	Replaced []string `json:"replaced"`
+	// Pruned are older rollbacks the retention policy removed.
+	Pruned     []string `json:"pruned,omitempty"`
+	PruneError string   `json:"pruneError,omitempty"`
+}
+
+// Stage reported while an in-place upgrade swaps the pack folders.
+const stageReplacing = "Replacing pack folders"
+
+// executeUpgrade stages the release inside the instance, moves the pack folders it
+// replaces into a new rollback and the new ones into place, then merges the user's
+// configs and compares mods exactly like a migration. A failed or cancelled
+// upgrade is rolled back straight away.
+func executeUpgrade(ctx context.Context, plan *migrationPlan, progress progressFunc) (report *migrationReport, err error) {
+	instance := plan.Destination
+	if !pathExists(instance) {
+		return nil, fmt.Errorf("instance not found: %s", instance)
+	}
+	if plan.Backup != nil && !plan.Backup.Enabled {
+		return nil, fmt.Errorf("an in-place upgrade backs up the worlds first; migrate to a new instance to skip the backup")
+	}
+
+	backup, err := backupWorlds(ctx, plan.Backup, func(done, total int64) {
+		progress(stageBackingUp, done, total)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("back up worlds: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "gtnh-updater-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	zipPath, err := downloadVersionZip(ctx, plan.Release.URL, tmpDir, func(downloaded, total int64) {
+		progress(stageDownloading, downloaded, total)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// staging inside the instance keeps the swaps below on one filesystem
+	staging := filepath.Join(updaterDir(instance), "staging")
+	if err = os.RemoveAll(staging); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	if err = extractZip(zipPath, staging, func(processed, total int, _ string) {
+		progress(stageExtracting, int64(processed), int64(total))
+	}); err != nil {
+		return nil, err
+	}
+	if err = maybeFlattenSingleDir(staging); err != nil {
+		return nil, err
+	}
+	stagedRoot := destinationRoot(staging, pathExists)
+	for _, name := range packEntriesRequired {
+		if !pathExists(filepath.Join(stagedRoot, name)) {
+			return nil, fmt.Errorf("the release has no %s folder, not upgrading", name)
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	journal, err := newRollbackJournal(instance, plan.Release.FileName)
+	if err != nil {
+		return nil, fmt.Errorf("start rollback journal: %w", err)
+	}
+	finished := false
+	defer func() {
+		if finished {
+			return
+		}
+		if undoErr := journal.undo(); undoErr != nil {
+			err = fmt.Errorf("%w; putting the instance back failed too (%v), run rollback to retry", err, undoErr)
+		}
+	}()
+
+	// the old baseline goes with the old pack; the merge below still needs it
+	baseline := filepath.Join(updaterDir(instance), "baseline")
+	if pathExists(baseline) {
+		if err = journal.backup(ctx, baseline); err != nil {
+			return nil, fmt.Errorf("back up the pack baseline: %w", err)
+		}
+	}
+	report = &migrationReport{Destination: instance, Backup: backup, Worlds: plan.Worlds, Upgrade: &upgradeResult{Rollback: journal.ID}}
+	for i, e := range plan.Replace {
+		progress(stageReplacing, int64(i), int64(len(plan.Replace)))
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		target := replaceTarget(e, instance)
+		if pathExists(target) {
+			if err = journal.backup(ctx, target); err != nil {
+				return nil, fmt.Errorf("back up %s: %w", e.Path, err)
+			}
+		}
+		staged := replaceTarget(e, staging)
+		if !pathExists(staged) {
+			continue
+		}
+		if err = journal.install(ctx, staged, target); err != nil {
+			return nil, fmt.Errorf("install %s: %w", e.Path, err)
+		}
+		report.Upgrade.Replaced = append(report.Upgrade.Replaced, e.Path)
+	}
+	progress(stageReplacing, int64(len(plan.Replace)), int64(len(plan.Replace)))
+
+	inRollback := func(p string) string {
+		rel, err := journal.relPath(p)
+		if err != nil {
+			return p
+		}
+		return journal.stored(rel)
+	}
+	if cm := plan.ConfigMerge; cm != nil {
+		cm.SourceDir, cm.BaselineDir = inRollback(cm.SourceDir), inRollback(cm.BaselineDir)
+	}
+	if mp := plan.Mods; mp != nil {
+		mp.SourceDir, mp.BaselineFile = inRollback(mp.SourceDir), inRollback(mp.BaselineFile)
+	}
+	if err = journal.record(opInstall, baseline); err != nil {
+		return nil, err
+	}
+	// the caller saves a new report once the user has picked their mods
+	if reportFile := migrationReportFile(instance); pathExists(reportFile) {
+		if err = journal.backup(ctx, reportFile); err != nil {
+			return nil, err
+		}
+	}
+	if err = journal.record(opInstall, migrationReportFile(instance)); err != nil {
+		return nil, err
+	}
+	if err = snapshotConfigBaseline(instance, plan.DestRoot); err != nil {
+		return nil, fmt.Errorf("snapshot pack configs: %w", err)
+	}
+	if err = snapshotModsBaseline(instance, plan.DestRoot); err != nil {
+		return nil, fmt.Errorf("snapshot pack mods: %w", err)
+	}
+	if report.ConfigMerge, err = mergeInstanceConfigs(plan.ConfigMerge, plan.DestRoot); err != nil {
+		return nil, err
+	}
+	if report.Mods, err = diffMods(plan.Mods, plan.DestRoot); err != nil {
+		return nil, fmt.Errorf("compare mods: %w", err)
+	}
+
+	journal.Complete = true
+	if err = journal.save(); err != nil {
+		return nil, err
+	}
+	finished = true
+	if plan.Rollbacks != nil {
+		// a failed prune leaves old rollbacks around, which is no reason to fail
+		pruned, pruneErr := pruneRollbacks(instance, plan.Rollbacks, false)
+		for _, it := range pruned {
+			report.Upgrade.Pruned = append(report.Upgrade.Pruned, filepath.Base(it.Path))
+		}
+		if pruneErr != nil {
+			report.Upgrade.PruneError = pruneErr.Error()
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTree writes files, keyed by slash-separated paths, under root.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for rel, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns every file under root, keyed by slash-separated paths.
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// serveRelease serves a release archive holding files and returns the release
+// the way probeRelease would describe it.
+func serveRelease(t *testing.T, files map[string]string) releasePlan {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	const name = "GTNH_2.8.0_Java_17-21.zip"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(buf.Bytes()))
+	}))
+	t.Cleanup(srv.Close)
+	return releasePlan{Version: "2.8.0", FileName: name, URL: srv.URL + "/" + name, Size: int64(buf.Len())}
+}
+
+// upgradeTestPlan plans an in-place upgrade of instance to release without the
+// config merge, mod comparison or backup buildUpgradePlan would add.
+func upgradeTestPlan(t *testing.T, instance string, release releasePlan) *migrationPlan {
+	t.Helper()
+	plan := &migrationPlan{Source: instance, Destination: instance, Release: release, InPlace: true, DestRoot: destinationRoot(instance, pathExists)}
+	for _, name := range packGameEntries {
+		e, err := replaceEntry(name, filepath.Join(plan.DestRoot, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan.Replace = append(plan.Replace, e)
+	}
+	for _, name := range packInstanceEntries {
+		e, err := replaceEntry(name, filepath.Join(instance, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan.Replace = append(plan.Replace, e)
+	}
+	return plan
+}
+
+// oldInstance is a 2.7 instance: pack folders plus the user's own data.
+var oldInstance = map[string]string{
+	"instance.cfg":                   "[General]\nname=GTNH\n",
+	"mmc-pack.json":                  `{"components": ["2.7"]}`,
+	".minecraft/mods/core-2.7.jar":   "old core",
+	".minecraft/config/core.cfg":     "speed=1",
+	".minecraft/scripts/old.zs":      "old script",
+	".minecraft/options.txt":         "fov:0.5",
+	".minecraft/saves/w/level.dat":   "level",
+	".minecraft/screenshots/a.png":   "png",
+	".minecraft/resourcepacks/x.zip": "pack",
+}
+
+func TestExecuteUpgrade(t *testing.T) {
+	release := map[string]string{
+		"GTNH/mmc-pack.json":                `{"components": ["2.8"]}`,
+		"GTNH/.minecraft/mods/core-2.8.jar": "new core",
+		"GTNH/.minecraft/config/core.cfg":   "speed=2",
+	}
+	tests := []struct {
+		name    string
+		release map[string]string
+		wantErr bool
+	}{
+		{name: "replaces the pack folders", release: release},
+		{name: "refuses a release without configs", release: map[string]string{"GTNH/.minecraft/mods/core-2.8.jar": "new core"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := filepath.Join(t.TempDir(), "GTNH")
+			writeTree(t, instance, oldInstance)
+			plan := upgradeTestPlan(t, instance, serveRelease(t, tt.release))
+
+			_, err := executeUpgrade(context.Background(), plan, func(string, int64, int64) {})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			want := make(map[string]string)
+			for rel, data := range oldInstance {
+				want[rel] = data
+			}
+			if !tt.wantErr {
+				// the pack's folders are swapped, scripts goes as the release has none
+				delete(want, ".minecraft/mods/core-2.7.jar")
+				delete(want, ".minecraft/scripts/old.zs")
+				want[".minecraft/mods/core-2.8.jar"] = "new core"
+				want[".minecraft/config/core.cfg"] = "speed=2"
+				want["mmc-pack.json"] = `{"components": ["2.8"]}`
+			}
+			got := readTree(t, instance)
+			for rel := range got {
+				// the replaced folders are kept in the updater's own folder
+				if strings.HasPrefix(rel, ".gtnh-updater/") {
+					delete(got, rel)
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("instance holds %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// worldEntries are the plan entries holding world data. A migration whose copy of
+// these does not verify is treated as failed.
+var worldEntries = map[string]bool{"saves": true}
+
+type verifyProblem struct {
+	Entry  string `json:"entry"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type verifyResult struct {
+	Files      int             `json:"files"`
+	Bytes      int64           `json:"bytes"`
+	SizeOnly   int             `json:"sizeOnly,omitempty"`
+	Mismatched []verifyProblem `json:"mismatched,omitempty"`
+	Missing    []verifyProblem `json:"missing,omitempty"`
+}
+
+// worldDataIntact reports whether every problem is outside the world folders.
+func (r *verifyResult) worldDataIntact() bool {
+	for _, p := range append(append([]verifyProblem{}, r.Mismatched...), r.Missing...) {
+		if worldEntries[p.Entry] {
+			return false
+		}
+	}
+	return true
+}
+
+// verify compares every file the engine transferred with its source: size first,
+// then a SHA-256 of both sides. Hardlinks are identical by definition and moved
+// files, whose source is gone, are checked by size only.
+func (e *copyEngine) verify(ctx context.Context, progress func(done, total int64)) (*verifyResult, error) {
+	res := &verifyResult{}
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		done     atomic.Int64
+		firstErr error
+	)
+	record := func(job *copyJob, missing bool, reason string) {
+		mu.Lock()
+		defer mu.Unlock()
+		p := verifyProblem{Entry: job.entry, Path: job.dst, Reason: reason}
+		if missing {
+			res.Missing = append(res.Missing, p)
+		} else {
+			res.Mismatched = append(res.Mismatched, p)
+		}
+	}
+
+	queue := make(chan *copyJob)
+	for i := 0; i < e.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if ctx.Err() != nil {
+					continue
+				}
+				missing, reason, sizeOnly, err := verifyJob(job)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					continue
+				}
+				if reason != "" {
+					record(job, missing, reason)
+				}
+				mu.Lock()
+				res.Files += job.files
+				res.Bytes += job.size
+				if sizeOnly {
+					res.SizeOnly += job.files
+				}
+				mu.Unlock()
+				n := done.Add(job.size)
+				if progress != nil {
+					progress(n, e.totalBytes)
+				}
+			}
+		}()
+	}
+	for i := range e.jobs {
+		select {
+		case queue <- &e.jobs[i]:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	sort.Slice(res.Mismatched, func(i, j int) bool { return res.Mismatched[i].Path < res.Mismatched[j].Path })
+	sort.Slice(res.Missing, func(i, j int) bool { return res.Missing[i].Path < res.Missing[j].Path })
+	return res, nil
+}
+
+// worldVerificationError lists the first few world files that did not verify.
+func worldVerificationError(r *verifyResult) error {
+	var problems []verifyProblem
+	for _, p := range append(append([]verifyProblem{}, r.Mismatched...), r.Missing...) {
+		if worldEntries[p.Entry] {
+			problems = append(problems, p)
+		}
+	}
+	msg := fmt.Sprintf("world data did not verify, %d files differ or are missing", len(problems))
+	for i, p := range problems {
+		if i == 5 {
+			msg += fmt.Sprintf("\n  ... and %d more", len(problems)-i)
+			break
+		}
+		msg += fmt.Sprintf("\n  %s: %s", p.Path, p.Reason)
+	}
+	return errors.New(msg)
+}
+
+func verifyJob(job *copyJob) (missing bool, reason string, sizeOnly bool, err error) {
+	if job.link != "" {
+		target, err := os.Readlink(job.dst)
+		if err != nil {
+			return true, "link missing", false, nil
+		}
+		if target != job.link {
+			return false, fmt.Sprintf("link points to %s, expected %s", target, job.link), false, nil
+		}
+		return false, "", false, nil
+	}
+	if job.tree {
+		if !pathExists(job.dst) {
+			return true, "folder missing", true, nil
+		}
+		files, size, err := dirStats(job.dst)
+		if err != nil {
+			return false, "", true, err
+		}
+		if files != job.files || size != job.size {
+			return false, fmt.Sprintf("moved folder has %d files / %s, expected %d / %s", files, formatBytes(size), job.files, formatBytes(job.size)), true, nil
+		}
+		return false, "", true, nil
+	}
+
+	dstInfo, err := os.Stat(job.dst)
+	if os.IsNotExist(err) {
+		return true, "file missing", false, nil
+	}
+	if err != nil {
+		return false, "", false, err
+	}
+	if job.mode == modeMove {
+		if dstInfo.Size() != job.size {
+			return false, fmt.Sprintf("size %d, expected %d", dstInfo.Size(), job.size), true, nil
+		}
+		return false, "", true, nil
+	}
+	srcInfo, err := os.Stat(job.src)
+	if err != nil {
+		return false, "", false, err
+	}
+	if os.SameFile(srcInfo, dstInfo) {
+		return false, "", false, nil
+	}
+	if srcInfo.Size() != dstInfo.Size() {
+		return false, fmt.Sprintf("size %d, expected %d", dstInfo.Size(), srcInfo.Size()), false, nil
+	}
+	srcSum, err := fileSHA256(job.src)
+	if err != nil {
+		return false, "", false, err
+	}
+	dstSum, err := fileSHA256(job.dst)
+	if err != nil {
+		return false, "", false, err
+	}
+	if !bytes.Equal(srcSum, dstSum) {
+		return false, "contents differ", false, nil
+	}
+	return false, "", false, nil
+}
+
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
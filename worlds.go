@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// packModID is the GTNH core mod; the version a world's FML mod list records for
+// it is the pack version the world was last played with.
+const packModID = "dreamcraft"
+
+var gameModes = map[int64]string{0: "survival", 1: "creative", 2: "adventure", 3: "spectator"}
+
+// worldInfo is what level.dat says about a world.
+type worldInfo struct {
+	Folder     string     `json:"folder"`
+	Name       string     `json:"name,omitempty"`
+	LastPlayed *time.Time `json:"lastPlayed,omitempty"`
+	GameMode   string     `json:"gameMode,omitempty"`
+	Hardcore   bool       `json:"hardcore,omitempty"`
+	Seed       int64      `json:"seed"`
+	Files      int        `json:"files"`
+	Bytes      int64      `json:"bytes"`
+	// Skip leaves the world out of the migration.
+	Skip bool `json:"skip,omitempty"`
+	// PackVersion is the GTNH version the world was last played with.
+	PackVersion string `json:"packVersion,omitempty"`
+	// Newer is set when PackVersion is newer than the release being installed.
+	Newer bool `json:"newer,omitempty"`
+	// Error says why level.dat could not be read.
+	Error string `json:"error,omitempty"`
+}
+
+// readWorldInfo reads the level.dat of the world in dir. A world whose level.dat
+// is missing or damaged gets Error set instead of failing.
+func readWorldInfo(dir string) worldInfo {
+	w := worldInfo{Folder: filepath.Base(dir)}
+	if files, bytes, err := dirStats(dir); err == nil {
+		w.Files, w.Bytes = files, bytes
+	}
+	root, err := readNBTFile(filepath.Join(dir, "level.dat"))
+	if os.IsNotExist(err) {
+		w.Error = "no level.dat"
+		return w
+	}
+	if err != nil {
+		w.Error = "level.dat unreadable: " + err.Error()
+		return w
+	}
+	data := root.compound("Data")
+	if data == nil {
+		w.Error = "level.dat has no Data tag"
+		return w
+	}
+	w.Name = data.str("LevelName")
+	if ms, ok := data.int("LastPlayed"); ok && ms > 0 {
+		t := time.UnixMilli(ms)
+		w.LastPlayed = &t
+	}
+	if mode, ok := data.int("GameType"); ok {
+		w.GameMode = gameModes[mode]
+		if w.GameMode == "" {
+			w.GameMode = fmt.Sprintf("mode %d", mode)
+		}
+	}
+	if hc, ok := data.int("hardcore"); ok {
+		w.Hardcore = hc != 0
+	}
+	w.Seed, _ = data.int("RandomSeed")
+	for _, m := range root.compound("FML").list("ModList") {
+		if mod, ok := m.(nbtCompound); ok && mod.str("ModId") == packModID {
+			w.PackVersion = mod.str("ModVersion")
+		}
+	}
+	return w
+}
+
+// planWorlds reads every world in savesDir and flags the ones last played with a
+// newer pack than target.
+func planWorlds(savesDir, target string) ([]worldInfo, error) {
+	entries, err := os.ReadDir(savesDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var worlds []worldInfo
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		w := readWorldInfo(filepath.Join(savesDir, e.Name()))
+		w.Newer = w.PackVersion != "" && target != "" && compareSemver(numericVersion(w.PackVersion), target) > 0
+		worlds = append(worlds, w)
+	}
+	sort.Slice(worlds, func(a, b int) bool { return worlds[a].Folder < worlds[b].Folder })
+	return worlds, nil
+}
+
+// packVersion pulls the pack version out of a release file name such as
+// GT_New_Horizons_2.7.2_Java_17-21.zip.
+func packVersion(fileName string) string {
+	info := parseReleaseInfo(fileName)
+	if info.releaseType == releaseUnknown && info.baseVersion == "0.0.0" {
+		return ""
+	}
+	return numericVersion(strings.TrimLeft(info.baseVersion, "abcdefghijklmnopqrstuvwxyz_-"))
+}
+
+// numericVersion keeps the leading dotted numbers of a version, so "2.7.2-pre"
+// compares as 2.7.2.
+func numericVersion(v string) string {
+	end := 0
+	for end < len(v) && (v[end] == '.' || v[end] >= '0' && v[end] <= '9') {
+		end++
+	}
+	return strings.Trim(v[:end], ".")
+}
+
+// skipWorlds leaves the named worlds out of the migration and migrates the rest.
+func (p *migrationPlan) skipWorlds(names []string) error {
+	skip := make(map[string]bool, len(names))
+	for _, n := range names {
+		skip[n] = true
+	}
+	for _, w := range p.Worlds {
+		delete(skip, w.Folder)
+	}
+	for n := range skip {
+		return fmt.Errorf("no world %q in %s", n, p.Source)
+	}
+	for i := range p.Entries {
+		e := &p.Entries[i]
+		if e.Path != "saves" {
+			continue
+		}
+		e.Skip = nil
+		for j := range p.Worlds {
+			w := &p.Worlds[j]
+			if w.Skip {
+				e.Files, e.Bytes = e.Files+w.Files, e.Bytes+w.Bytes
+			}
+			w.Skip = false
+			for _, n := range names {
+				w.Skip = w.Skip || n == w.Folder
+			}
+			if w.Skip {
+				e.Skip = append(e.Skip, w.Folder)
+				e.Files, e.Bytes = e.Files-w.Files, e.Bytes-w.Bytes
+			}
+		}
+	}
+	return nil
+}
+
+func (p *migrationPlan) skippedWorlds() []string {
+	var names []string
+	for _, w := range p.Worlds {
+		if w.Skip {
+			names = append(names, w.Folder)
+		}
+	}
+	return names
+}
+
+func (w worldInfo) describe() string {
+	if w.Error != "" {
+		return fmt.Sprintf("%s  %s, %s", w.Folder, w.Error, formatBytes(w.Bytes))
+	}
+	line := w.Folder
+	if w.Name != "" && w.Name != w.Folder {
+		line += fmt.Sprintf(" %q", w.Name)
+	}
+	mode := w.GameMode
+	if w.Hardcore {
+		mode += " (hardcore)"
+	}
+	line += fmt.Sprintf("  %s, seed %d", mode, w.Seed)
+	if w.LastPlayed != nil {
+		line += ", last played " + w.LastPlayed.Format("2006-01-02 15:04")
+	}
+	if w.PackVersion != "" {
+		line += " with GTNH " + w.PackVersion
+	}
+	return line + ", " + formatBytes(w.Bytes)
+}
+
+// checkWorldCopies reads the level.dat of every copied world under savesDir.
+// Worlds whose original level.dat could not be read either are skipped.
+func checkWorldCopies(worlds []worldInfo, savesDir string) error {
+	var broken []string
+	for _, w := range worlds {
+		if w.Error != "" || w.Skip {
+			continue
+		}
+		if _, err := readNBTFile(filepath.Join(savesDir, w.Folder, "level.dat")); err != nil {
+			broken = append(broken, fmt.Sprintf("%s: %v", w.Folder, err))
+		}
+	}
+	if len(broken) > 0 {
+		return errors.New("the copied level.dat does not read back for " + strings.Join(broken, "; "))
+	}
+	return nil
+}